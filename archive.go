@@ -0,0 +1,342 @@
+package libcarina
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxCredentialsSize is the default limit on the size of a credentials zip, and of its extracted contents
+	DefaultMaxCredentialsSize = 1 << 20
+
+	// DefaultMaxCredentialsFileSize is the default limit on the size of a single file in a credentials bundle
+	DefaultMaxCredentialsFileSize = 256 << 10
+)
+
+// KnownCredentialsFiles is the set of files that Carina includes in a credentials bundle
+var KnownCredentialsFiles = []string{
+	"README.md",
+	"ca.pem", "cert.pem", "key.pem",
+	"docker.env", "docker.fish", "docker.ps1", "docker.cmd",
+	"kubectl.config", "kubectl.env", "kubectl.fish", "kubectl.ps1", "kubectl.cmd",
+	"mesos.env",
+}
+
+// UnknownFilePolicy determines what happens to files in a credentials zip which are not expected
+type UnknownFilePolicy int
+
+const (
+	// UnknownFilesKeep includes unexpected files in the credentials bundle, the default
+	UnknownFilesKeep UnknownFilePolicy = iota
+
+	// UnknownFilesSkip ignores unexpected files
+	UnknownFilesSkip
+
+	// UnknownFilesReject fails when an unexpected file is found
+	UnknownFilesReject
+)
+
+// GetCredentialsOpts defines the set of parameters when downloading a credentials bundle
+type GetCredentialsOpts struct {
+	// MaxTotalSize limits the size of the zip and of its extracted contents, defaults to DefaultMaxCredentialsSize
+	MaxTotalSize int64
+
+	// MaxFileSize limits the size of each extracted file, defaults to DefaultMaxCredentialsFileSize
+	MaxFileSize int64
+
+	// AllowedFiles lists the expected file names, defaults to KnownCredentialsFiles
+	AllowedFiles []string
+
+	// UnknownFiles determines what happens to files which are not in AllowedFiles, kept by default
+	UnknownFiles UnknownFilePolicy
+
+	// KeepRawZip saves the zip, exactly as returned by the server, in CredentialsBundle.RawZip
+	KeepRawZip bool
+}
+
+func (opts *GetCredentialsOpts) maxTotalSize() int64 {
+	if opts == nil || opts.MaxTotalSize <= 0 {
+		return DefaultMaxCredentialsSize
+	}
+	return opts.MaxTotalSize
+}
+
+func (opts *GetCredentialsOpts) maxFileSize() int64 {
+	if opts == nil || opts.MaxFileSize <= 0 {
+		return DefaultMaxCredentialsFileSize
+	}
+	return opts.MaxFileSize
+}
+
+func (opts *GetCredentialsOpts) isAllowed(fileName string) bool {
+	allowed := KnownCredentialsFiles
+	if opts != nil && opts.AllowedFiles != nil {
+		allowed = opts.AllowedFiles
+	}
+	for _, name := range allowed {
+		if name == fileName {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *GetCredentialsOpts) unknownFiles() UnknownFilePolicy {
+	if opts == nil {
+		return UnknownFilesKeep
+	}
+	return opts.UnknownFiles
+}
+
+// ReadCredentialsZip loads a credentials bundle from a zip, such as one written by WriteZip.
+// Only the limits and file filtering in opts apply.
+func ReadCredentialsZip(r io.Reader, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	return readCredentialsZip(r, opts)
+}
+
+// ReadCredentialsTarGz loads a credentials bundle from a gzipped tarball, such as one written by WriteTarGz.
+// Only the limits and file filtering in opts apply.
+func ReadCredentialsTarGz(r io.Reader, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	maxTotalSize := opts.maxTotalSize()
+
+	// Limit the compressed stream as well as the extracted files, so that a huge tarball of skipped files is not read forever
+	limited := &io.LimitedReader{R: r, N: maxTotalSize + 1}
+	gzr, err := gzip.NewReader(limited)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid credentials bundle")
+	}
+	defer gzr.Close()
+
+	x := newCredentialsExtractor(opts)
+	tarr := tar.NewReader(gzr)
+	for {
+		hdr, err := tarr.Next()
+		if err == io.EOF {
+			break
+		}
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("Invalid credentials bundle. The tarball exceeds the maximum size of %d bytes", maxTotalSize)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid credentials bundle")
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			continue
+		default:
+			return nil, fmt.Errorf("Invalid credentials bundle. %s is not a regular file", hdr.Name)
+		}
+
+		err = x.extract(hdr.Name, hdr.Size, func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(tarr), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return x.creds, nil
+}
+
+// DecodeCredentialsBase64 loads a credentials bundle from the single string form created by EncodeBase64.
+// Only the limits and file filtering in opts apply.
+func DecodeCredentialsBase64(encoded string, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	// Tolerate the line wrapping added by tools such as base64 and secret managers
+	encoded = strings.Join(strings.Fields(encoded), "")
+	zipBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid credentials bundle. Unable to decode base64")
+	}
+	return readCredentialsZip(bytes.NewReader(zipBytes), opts)
+}
+
+// WriteZip writes every file in the credentials bundle to a zip
+func (creds *CredentialsBundle) WriteZip(w io.Writer) error {
+	zipw := zip.NewWriter(w)
+	for _, fileName := range creds.sortedFileNames() {
+		hdr := &zip.FileHeader{Name: fileName, Method: zip.Deflate}
+		hdr.SetMode(0600)
+		fw, err := zipw.CreateHeader(hdr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = fw.Write(creds.Files[fileName])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(zipw.Close())
+}
+
+// WriteTarGz writes every file in the credentials bundle to a gzipped tarball
+func (creds *CredentialsBundle) WriteTarGz(w io.Writer) error {
+	gzw := gzip.NewWriter(w)
+	tarw := tar.NewWriter(gzw)
+	for _, fileName := range creds.sortedFileNames() {
+		contents := creds.Files[fileName]
+		hdr := &tar.Header{
+			Name:     fileName,
+			Mode:     0600,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(0, 0),
+		}
+		err := tarw.WriteHeader(hdr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = tarw.Write(contents)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err := tarw.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(gzw.Close())
+}
+
+// EncodeBase64 returns the credentials bundle as a single base64 encoded zip, suitable for an environment variable or secret
+func (creds *CredentialsBundle) EncodeBase64() (string, error) {
+	buf := &bytes.Buffer{}
+	err := creds.WriteZip(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func (creds *CredentialsBundle) sortedFileNames() []string {
+	fileNames := make([]string, 0, len(creds.Files))
+	for fileName := range creds.Files {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	return fileNames
+}
+
+// readCredentialsZip extracts a credentials bundle from a zip, enforcing the limits in opts
+func readCredentialsZip(r io.Reader, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	maxTotalSize := opts.maxTotalSize()
+
+	// The zip index is at the end of the file, so it must be buffered, but never beyond the limit
+	zipBytes, err := ioutil.ReadAll(io.LimitReader(r, maxTotalSize+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if int64(len(zipBytes)) > maxTotalSize {
+		return nil, fmt.Errorf("Invalid credentials bundle. The zip exceeds the maximum size of %d bytes", maxTotalSize)
+	}
+
+	zipr, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid credentials bundle")
+	}
+
+	x := newCredentialsExtractor(opts)
+	for _, zf := range zipr.File {
+		if zf.FileInfo().IsDir() {
+			// Explicitly skip past directories (the UUID directory from a previous release)
+			continue
+		}
+
+		// Check the declared size up front, the extractor enforces it while reading in case the header lies
+		size := int64(zf.UncompressedSize64)
+		if zf.UncompressedSize64 > uint64(maxTotalSize) {
+			size = maxTotalSize + 1
+		}
+		err = x.extract(zf.Name, size, zf.Open)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts != nil && opts.KeepRawZip {
+		x.creds.RawZip = zipBytes
+	}
+
+	return x.creds, nil
+}
+
+// credentialsExtractor adds files from an archive to a credentials bundle.
+// Directories in the archive are flattened, so entries which share a file name are rejected.
+type credentialsExtractor struct {
+	opts      *GetCredentialsOpts
+	creds     *CredentialsBundle
+	sources   map[string]string
+	totalSize int64
+}
+
+func newCredentialsExtractor(opts *GetCredentialsOpts) *credentialsExtractor {
+	return &credentialsExtractor{
+		opts:    opts,
+		creds:   NewCredentialsBundle(),
+		sources: make(map[string]string),
+	}
+}
+
+func (x *credentialsExtractor) extract(entryName string, size int64, open func() (io.ReadCloser, error)) error {
+	maxTotalSize := x.opts.maxTotalSize()
+	maxFileSize := x.opts.maxFileSize()
+
+	_, fileName := path.Split(entryName)
+	if fileName == "" || fileName == "." || fileName == ".." || strings.ContainsAny(fileName, `\:`) {
+		return fmt.Errorf("Invalid credentials bundle. Unsafe file name %q", entryName)
+	}
+
+	if !x.opts.isAllowed(fileName) {
+		switch x.opts.unknownFiles() {
+		case UnknownFilesKeep:
+		case UnknownFilesReject:
+			return fmt.Errorf("Invalid credentials bundle. Unexpected file %s", entryName)
+		default:
+			return nil
+		}
+	}
+
+	if source, ok := x.sources[fileName]; ok {
+		return fmt.Errorf("Invalid credentials bundle. Both %s and %s are named %s", source, entryName, fileName)
+	}
+	x.sources[fileName] = entryName
+
+	if size > maxFileSize {
+		return fmt.Errorf("Invalid credentials bundle. %s exceeds the maximum file size of %d bytes", entryName, maxFileSize)
+	}
+
+	rc, err := open()
+	if err != nil {
+		return errors.Wrapf(err, "Invalid credentials bundle. Unable to read %s", entryName)
+	}
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return errors.Wrapf(err, "Invalid credentials bundle. Unable to read %s", entryName)
+	}
+	if int64(len(contents)) > maxFileSize {
+		return fmt.Errorf("Invalid credentials bundle. %s exceeds the maximum file size of %d bytes", entryName, maxFileSize)
+	}
+
+	x.totalSize += int64(len(contents))
+	if x.totalSize > maxTotalSize {
+		return fmt.Errorf("Invalid credentials bundle. The extracted files exceed the maximum size of %d bytes", maxTotalSize)
+	}
+
+	x.creds.Files[fileName] = contents
+	return nil
+}
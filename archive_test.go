@@ -0,0 +1,163 @@
+package libcarina
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testZipEntry struct {
+	name     string
+	contents string
+}
+
+func newTestZip(t *testing.T, entries ...testZipEntry) []byte {
+	buf := &bytes.Buffer{}
+	zipw := zip.NewWriter(buf)
+	for _, entry := range entries {
+		w, err := zipw.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadCredentialsZip(t *testing.T) {
+	zipBytes := newTestZip(t,
+		testZipEntry{"9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c/", ""},
+		testZipEntry{"9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c/ca.pem", "fake-ca"},
+		testZipEntry{"9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c/docker.env", swarmDockerEnv},
+		testZipEntry{"9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c/surprise.sh", "rm -rf /"})
+
+	creds, err := readCredentialsZip(bytes.NewReader(zipBytes), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(creds.Files["ca.pem"]) != "fake-ca" || string(creds.Files["docker.env"]) != swarmDockerEnv {
+		t.Error("unexpected files", creds.Files)
+	}
+	if _, ok := creds.Files["surprise.sh"]; !ok {
+		t.Error("expected unknown files to be kept by default")
+	}
+
+	creds, err = readCredentialsZip(bytes.NewReader(zipBytes), &GetCredentialsOpts{UnknownFiles: UnknownFilesSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := creds.Files["surprise.sh"]; ok {
+		t.Error("expected unknown files to be skipped")
+	}
+
+	_, err = readCredentialsZip(bytes.NewReader(zipBytes), &GetCredentialsOpts{UnknownFiles: UnknownFilesReject})
+	if err == nil || !strings.Contains(err.Error(), "surprise.sh") {
+		t.Error("expected unknown files to be rejected, got", err)
+	}
+}
+
+func TestReadCredentialsZipLimits(t *testing.T) {
+	big := strings.Repeat("a", 1024)
+	zipBytes := newTestZip(t, testZipEntry{"ca.pem", big}, testZipEntry{"cert.pem", big}, testZipEntry{"key.pem", big})
+
+	testCases := []struct {
+		opts    *GetCredentialsOpts
+		message string
+	}{
+		{&GetCredentialsOpts{MaxFileSize: 1023}, "maximum file size"},
+		{&GetCredentialsOpts{MaxTotalSize: 100}, "zip exceeds the maximum size"},
+		{&GetCredentialsOpts{MaxTotalSize: 2048 + 1}, "extracted files exceed the maximum size"},
+	}
+
+	for _, tc := range testCases {
+		if int64(len(zipBytes)) > tc.opts.maxTotalSize() && tc.message != "zip exceeds the maximum size" {
+			t.Fatalf("test zip is too large (%d bytes) to exercise %q", len(zipBytes), tc.message)
+		}
+		_, err := readCredentialsZip(bytes.NewReader(zipBytes), tc.opts)
+		if err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("expected an error containing %q, got %v", tc.message, err)
+		}
+	}
+}
+
+func TestReadCredentialsZipCollisions(t *testing.T) {
+	zipBytes := newTestZip(t, testZipEntry{"a/key.pem", "one"}, testZipEntry{"b/key.pem", "two"})
+	_, err := readCredentialsZip(bytes.NewReader(zipBytes), nil)
+	if err == nil || !strings.Contains(err.Error(), "a/key.pem") || !strings.Contains(err.Error(), "b/key.pem") {
+		t.Error("expected a collision error naming both files, got", err)
+	}
+}
+
+func TestCredentialsArchiveRoundTrip(t *testing.T) {
+	creds := newTestSwarmBundle("mycluster")
+	creds.Files["key.pem"] = []byte("fake-key")
+
+	assertSameFiles := func(format string, actual *CredentialsBundle, err error) {
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			return
+		}
+		if len(actual.Files) != len(creds.Files) {
+			t.Errorf("%s: expected %d files, got %d", format, len(creds.Files), len(actual.Files))
+		}
+		for fileName, contents := range creds.Files {
+			if !bytes.Equal(actual.Files[fileName], contents) {
+				t.Errorf("%s: unexpected contents for %s: %s", format, fileName, actual.Files[fileName])
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := creds.WriteZip(buf); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ReadCredentialsZip(buf, nil)
+	assertSameFiles("zip", actual, err)
+
+	buf.Reset()
+	if err = creds.WriteTarGz(buf); err != nil {
+		t.Fatal(err)
+	}
+	actual, err = ReadCredentialsTarGz(buf, nil)
+	assertSameFiles("tar.gz", actual, err)
+
+	encoded, err := creds.EncodeBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(encoded, "\n ") {
+		t.Error("expected a single line, got", encoded)
+	}
+	actual, err = DecodeCredentialsBase64(encoded[:40]+"\n"+encoded[40:], nil)
+	assertSameFiles("base64", actual, err)
+}
+
+func TestReadCredentialsTarGzLimits(t *testing.T) {
+	creds := newTestBundle(map[string]string{"ca.pem": strings.Repeat("a", 1024)})
+	buf := &bytes.Buffer{}
+	if err := creds.WriteTarGz(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadCredentialsTarGz(bytes.NewReader(buf.Bytes()), &GetCredentialsOpts{MaxFileSize: 100})
+	if err == nil || !strings.Contains(err.Error(), "maximum file size") {
+		t.Error("expected the file size limit to be enforced, got", err)
+	}
+}
+
+func TestKeepRawZip(t *testing.T) {
+	zipBytes := newTestZip(t, testZipEntry{"ca.pem", "fake-ca"})
+	creds, err := readCredentialsZip(bytes.NewReader(zipBytes), &GetCredentialsOpts{KeepRawZip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(creds.RawZip, zipBytes) {
+		t.Error("expected the raw zip to be kept")
+	}
+}
@@ -0,0 +1,79 @@
+// Package carinaotel adapts OpenTelemetry tracing to libcarina.Tracer
+package carinaotel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getcarina/libcarina"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName identifies the spans created by libcarina
+const InstrumentationName = "github.com/getcarina/libcarina"
+
+// Tracer implements libcarina.Tracer with an OpenTelemetry tracer, propagating W3C trace context in request headers
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var _ libcarina.Tracer = &Tracer{}
+
+// NewTracer creates a Tracer which records spans with provider, e.g. otel.GetTracerProvider()
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:     provider.Tracer(InstrumentationName, trace.WithInstrumentationVersion(libcarina.LibVersion)),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Start creates a span, HTTP request spans are client spans
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, libcarina.Span) {
+	kind := trace.SpanKindInternal
+	if strings.HasPrefix(name, "HTTP ") {
+		kind = trace.SpanKindClient
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &otelSpan{span}
+}
+
+// Inject adds the traceparent header for the span in ctx
+func (t *Tracer) Inject(ctx context.Context, header http.Header) {
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
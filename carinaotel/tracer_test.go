@@ -0,0 +1,92 @@
+package carinaotel_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/getcarina/libcarina"
+	"github.com/getcarina/libcarina/carinaotel"
+	"github.com/getcarina/libcarina/carinatest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	server := carinatest.NewServer()
+	defer server.Close()
+	server.AddCluster(libcarina.Cluster{Name: "mycluster"})
+
+	client, err := libcarina.NewClientWithOptions(server.Username, server.APIKey, server.Region, server.IdentityEndpoint(), "", "",
+		&libcarina.ClientOpts{Tracer: carinaotel.NewTracer(provider)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var traceparents []string
+	client.Use(func(next libcarina.Doer) libcarina.Doer {
+		return libcarina.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			traceparents = append(traceparents, req.Header.Get("traceparent"))
+			return next.Do(req)
+		})
+	})
+
+	if _, err = client.GetCredentials("mycluster"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatal("expected a span for the operation and each request, got", len(spans))
+	}
+	parent := spans[len(spans)-1]
+	if parent.Name() != "libcarina.GetCredentials" {
+		t.Fatal("expected the operation span to end last, got", parent.Name())
+	}
+	for _, span := range spans[:2] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Error("expected the request span to be a child of the operation", span.Name())
+		}
+		if span.SpanKind() != trace.SpanKindClient || span.Name() != "HTTP GET" {
+			t.Error("unexpected request span", span.Name(), span.SpanKind())
+		}
+	}
+	for i, traceparent := range traceparents {
+		expected := fmt.Sprintf("00-%s-%s-01", spans[i].SpanContext().TraceID(), spans[i].SpanContext().SpanID())
+		if traceparent != expected {
+			t.Errorf("expected traceparent %s, got %s", expected, traceparent)
+		}
+	}
+
+	_, err = client.Get("missing")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	spans = recorder.Ended()
+	if span := spans[len(spans)-1]; span.Name() != "libcarina.Get" || span.Status().Code.String() != "Error" {
+		t.Error("expected the failed operation to be recorded", span.Name(), span.Status())
+	}
+
+	ctx, caller := provider.Tracer("test").Start(context.Background(), "caller")
+	traceparents = nil
+	if _, err = client.GetContext(ctx, "mycluster"); err != nil {
+		t.Fatal(err)
+	}
+	caller.End()
+	spans = recorder.Ended()
+	operation := spans[len(spans)-2]
+	if operation.Name() != "libcarina.Get" || operation.Parent().SpanID() != caller.SpanContext().SpanID() {
+		t.Error("expected the operation span to be a child of the caller's span", operation.Name())
+	}
+	for _, traceparent := range traceparents {
+		if !strings.Contains(traceparent, caller.SpanContext().TraceID().String()) {
+			t.Errorf("expected the caller's trace to be propagated, got %s", traceparent)
+		}
+	}
+}
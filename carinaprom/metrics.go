@@ -0,0 +1,84 @@
+// Package carinaprom records libcarina metrics in a Prometheus registry
+package carinaprom
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/getcarina/libcarina"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace prefixes the names of the metrics, e.g. libcarina_requests_total
+const Namespace = "libcarina"
+
+// Metrics implements libcarina.Metrics with Prometheus collectors
+type Metrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+	reauths  prometheus.Counter
+	lookups  *prometheus.CounterVec
+}
+
+var _ libcarina.Metrics = &Metrics{}
+
+// NewMetrics creates the collectors and registers them with registerer, e.g. prometheus.DefaultRegisterer
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "requests_total",
+			Help:      "Requests to the Carina API by operation and HTTP status code, where 0 means no response was received.",
+		}, []string{"operation", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests to the Carina API by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "retries_total",
+			Help:      "Retried requests to the Carina API by operation.",
+		}, []string{"operation"}),
+		reauths: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "reauthentications_total",
+			Help:      "Times a cached token was rejected and the client authenticated again.",
+		}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "cluster_lookups_total",
+			Help:      "Times the clusters were listed to resolve a cluster name or id, by operation.",
+		}, []string{"operation"}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.requests, m.latency, m.retries, m.reauths, m.lookups} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ObserveRequest counts the request and records its latency
+func (m *Metrics) ObserveRequest(operation string, statusCode int, duration time.Duration) {
+	m.requests.WithLabelValues(operation, strconv.Itoa(statusCode)).Inc()
+	m.latency.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// IncRetry counts a retried request
+func (m *Metrics) IncRetry(operation string) {
+	m.retries.WithLabelValues(operation).Inc()
+}
+
+// IncReauthentication counts authenticating again after the cached token was rejected
+func (m *Metrics) IncReauthentication() {
+	m.reauths.Inc()
+}
+
+// IncClusterLookup counts listing the clusters to resolve a cluster name or id
+func (m *Metrics) IncClusterLookup(operation string) {
+	m.lookups.WithLabelValues(operation).Inc()
+}
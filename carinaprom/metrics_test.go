@@ -0,0 +1,47 @@
+package carinaprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics.ObserveRequest("List", 200, 150*time.Millisecond)
+	metrics.ObserveRequest("List", 200, 50*time.Millisecond)
+	metrics.ObserveRequest("Get", 404, time.Millisecond)
+	metrics.IncRetry("List")
+	metrics.IncReauthentication()
+	metrics.IncClusterLookup("GetCredentials")
+
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("List", "200")); n != 2 {
+		t.Error("expected 2 successful List requests, got", n)
+	}
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("Get", "404")); n != 1 {
+		t.Error("expected 1 failed Get request, got", n)
+	}
+	if n := testutil.ToFloat64(metrics.retries.WithLabelValues("List")); n != 1 {
+		t.Error("expected 1 retry, got", n)
+	}
+	if n := testutil.ToFloat64(metrics.reauths); n != 1 {
+		t.Error("expected 1 reauthentication, got", n)
+	}
+	if n := testutil.ToFloat64(metrics.lookups.WithLabelValues("GetCredentials")); n != 1 {
+		t.Error("expected 1 lookup, got", n)
+	}
+	if n := testutil.CollectAndCount(metrics.latency); n != 2 {
+		t.Error("expected latency histograms for 2 operations, got", n)
+	}
+
+	if _, err = NewMetrics(registry); err == nil {
+		t.Error("expected an error when registering the metrics twice")
+	}
+}
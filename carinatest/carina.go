@@ -0,0 +1,237 @@
+package carinatest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/getcarina/libcarina"
+)
+
+const supportedAPIVersion = libcarina.CarinaEndpointType + " " + libcarina.SupportedAPIVersion
+
+func (s *Server) serveCarina(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version := r.Header.Get("API-Version"); version != "" && version != supportedAPIVersion {
+		writeJSON(w, http.StatusNotAcceptable, libcarina.CarinaUnacceptableErrorResonse{
+			Errors: []libcarina.CarinaUnacceptableError{{
+				CarinaError: libcarina.CarinaError{
+					Code:      "make-coe-api.microverion-unsupported",
+					Detail:    fmt.Sprintf("If the api-version header is sent, it must be in the format '%s X.Y' where %s <= X.Y <= %s", libcarina.CarinaEndpointType, libcarina.SupportedAPIVersion, libcarina.SupportedAPIVersion),
+					RequestID: newUUID(),
+					Status:    http.StatusNotAcceptable,
+					Title:     "Microversion unsupported",
+				},
+				MaxVersion: libcarina.SupportedAPIVersion,
+				MinVersion: libcarina.SupportedAPIVersion,
+			}},
+		})
+		return
+	}
+
+	if !s.validToken(r.Header.Get("X-Auth-Token")) {
+		writeError(w, http.StatusUnauthorized, "make-coe-api.unauthorized", "Unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "cluster_types" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"cluster_types": s.clusterTypes})
+	case len(parts) == 1 && parts[0] == "clusters" && r.Method == "GET":
+		s.listClusters(w, r)
+	case len(parts) == 1 && parts[0] == "clusters" && r.Method == "POST":
+		s.createCluster(w, r)
+	case len(parts) >= 2 && parts[0] == "clusters":
+		cluster := s.findCluster(parts[1])
+		if cluster == nil {
+			writeError(w, http.StatusNotFound, "make-coe-api.cluster-not-found", fmt.Sprintf("Cluster %s not found", parts[1]))
+			return
+		}
+		s.serveCluster(w, r, cluster, strings.Join(parts[2:], "/"))
+	default:
+		writeError(w, http.StatusNotFound, "make-coe-api.not-found", "Not Found")
+	}
+}
+
+func (s *Server) serveCluster(w http.ResponseWriter, r *http.Request, cluster *libcarina.Cluster, resource string) {
+	switch {
+	case resource == "" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, cluster)
+		settle(cluster)
+	case resource == "" && r.Method == "DELETE":
+		s.deleteCluster(w, cluster)
+	case resource == "tasks" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": s.tasks[cluster.ID]})
+	case resource == "tasks" && r.Method == "POST":
+		s.createTask(w, r, cluster)
+	case resource == "credentials/zip" && r.Method == "GET":
+		s.serveCredentials(w, cluster)
+	default:
+		writeError(w, http.StatusNotFound, "make-coe-api.not-found", "Not Found")
+	}
+}
+
+// listClusters supports the limit, marker and status query parameters, any other filters are ignored
+func (s *Server) listClusters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	marker := query.Get("marker")
+	if marker != "" && s.findCluster(marker) == nil {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-request", fmt.Sprintf("Marker %s not found", marker))
+		return
+	}
+
+	clusters := []*libcarina.Cluster{}
+	found := marker == ""
+	for _, cluster := range s.clusters {
+		if !found {
+			found = strings.EqualFold(cluster.ID, marker)
+			continue
+		}
+		if status := query.Get("status"); status != "" && !strings.EqualFold(cluster.Status, status) {
+			continue
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "make-coe-api.invalid-request", fmt.Sprintf("Invalid limit %s", limit))
+			return
+		}
+		if n < len(clusters) {
+			clusters = clusters[:n]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clusters": clusters})
+}
+
+func (s *Server) createCluster(w http.ResponseWriter, r *http.Request) {
+	var opts libcarina.CreateClusterOpts
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-request", "Invalid request body")
+		return
+	}
+	if opts.Name == "" {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-request", "A cluster name is required")
+		return
+	}
+	for _, cluster := range s.clusters {
+		if strings.EqualFold(cluster.Name, opts.Name) {
+			writeError(w, http.StatusConflict, "make-coe-api.cluster-exists", fmt.Sprintf("Cluster %s already exists", opts.Name))
+			return
+		}
+	}
+
+	var clusterType *libcarina.ClusterType
+	for _, t := range s.clusterTypes {
+		if t.ID == opts.ClusterTypeID && t.IsActive {
+			clusterType = t
+		}
+	}
+	if clusterType == nil {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-cluster-type", fmt.Sprintf("Cluster type %d is not available", opts.ClusterTypeID))
+		return
+	}
+
+	nodes := opts.Nodes
+	if nodes == 0 {
+		nodes = 1
+	}
+	cluster := &libcarina.Cluster{
+		ID:     newUUID(),
+		Name:   opts.Name,
+		Type:   clusterType,
+		Nodes:  nodes,
+		Status: StatusCreating,
+	}
+	s.clusters = append(s.clusters, cluster)
+	writeJSON(w, http.StatusCreated, cluster)
+}
+
+func (s *Server) deleteCluster(w http.ResponseWriter, cluster *libcarina.Cluster) {
+	for i, c := range s.clusters {
+		if c == cluster {
+			s.clusters = append(s.clusters[:i], s.clusters[i+1:]...)
+			break
+		}
+	}
+	delete(s.credentials, cluster.ID)
+	delete(s.tasks, cluster.ID)
+
+	cluster.Status = StatusDeleting
+	writeJSON(w, http.StatusOK, cluster)
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request, cluster *libcarina.Cluster) {
+	var task Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-request", "Invalid request body")
+		return
+	}
+	if task.Type != "resize" {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-task", fmt.Sprintf("Unsupported task type %q", task.Type))
+		return
+	}
+	nodes, ok := task.Input["node_count"].(float64)
+	if !ok || nodes < 1 || nodes != float64(int(nodes)) {
+		writeError(w, http.StatusBadRequest, "make-coe-api.invalid-task", "node_count must be a positive integer")
+		return
+	}
+
+	task.ID = newUUID()
+	task.Status = "accepted"
+	s.tasks[cluster.ID] = append(s.tasks[cluster.ID], &task)
+
+	cluster.Nodes = int(nodes)
+	cluster.Status = StatusResizing
+	writeJSON(w, http.StatusCreated, task)
+}
+
+func (s *Server) serveCredentials(w http.ResponseWriter, cluster *libcarina.Cluster) {
+	creds, ok := s.credentials[cluster.ID]
+	if !ok {
+		coe := libcarina.COE(cluster.Type.COE)
+		host := fmt.Sprintf("10.0.%d.%d", len(s.credentials)/250, len(s.credentials)%250+1)
+
+		var err error
+		creds, err = NewCredentialsBundle(coe, host)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "make-coe-api.internal-error", err.Error())
+			return
+		}
+		s.credentials[cluster.ID] = creds
+	}
+
+	var zip bytes.Buffer
+	if err := creds.WriteZip(&zip); err != nil {
+		writeError(w, http.StatusInternalServerError, "make-coe-api.internal-error", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Write(zip.Bytes())
+}
+
+func (s *Server) findCluster(id string) *libcarina.Cluster {
+	for _, cluster := range s.clusters {
+		if strings.EqualFold(cluster.ID, id) {
+			return cluster
+		}
+	}
+	return nil
+}
+
+// settle completes a create or resize once the cluster has been fetched with its transitional status
+func settle(cluster *libcarina.Cluster) {
+	if cluster.Status == StatusCreating || cluster.Status == StatusResizing {
+		cluster.Status = StatusActive
+	}
+}
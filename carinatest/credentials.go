@@ -0,0 +1,111 @@
+package carinatest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/getcarina/libcarina"
+)
+
+// credentialsValidity is how long the generated client certificates are valid
+const credentialsValidity = 30 * 24 * time.Hour
+
+// NewCredentialsBundle generates a credentials bundle for a cluster, with a fresh CA and client certificate,
+// and the scripts for its COE pointing at host, e.g. 10.0.0.1
+func NewCredentialsBundle(coe libcarina.COE, host string) (*libcarina.CredentialsBundle, error) {
+	ca, cert, key, err := newCertificates(time.Now().Add(credentialsValidity))
+	if err != nil {
+		return nil, err
+	}
+
+	creds := libcarina.NewCredentialsBundle()
+	creds.Files["ca.pem"] = ca
+	creds.Files["cert.pem"] = cert
+	creds.Files["key.pem"] = key
+
+	switch coe {
+	case libcarina.KubernetesCOE:
+		creds.Files["kubectl.config"] = []byte(fmt.Sprintf(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: ca.pem
+    server: https://%s:6443
+  name: carinatest
+contexts:
+- context:
+    cluster: carinatest
+    user: admin
+  name: default
+current-context: default
+kind: Config
+users:
+- name: admin
+  user:
+    client-certificate: cert.pem
+    client-key: key.pem
+`, host))
+		creds.Files["kubectl.env"] = []byte("export KUBECONFIG=$(pwd)/kubectl.config\n")
+		creds.Files["kubectl.ps1"] = []byte("$env:KUBECONFIG=\"$PSScriptRoot\\kubectl.config\"\n")
+		creds.Files["kubectl.cmd"] = []byte("set KUBECONFIG=%~dp0kubectl.config\n")
+		creds.Files["kubectl.fish"] = []byte("set -x KUBECONFIG (pwd)/kubectl.config\n")
+	default:
+		creds.Files["docker.env"] = []byte(fmt.Sprintf("export DOCKER_HOST=tcp://%s:2376\nexport DOCKER_TLS_VERIFY=1\nexport DOCKER_CERT_PATH=$(pwd)\n", host))
+		creds.Files["docker.ps1"] = []byte(fmt.Sprintf("$env:DOCKER_HOST='tcp://%s:2376'\n$env:DOCKER_TLS_VERIFY='1'\n$env:DOCKER_CERT_PATH=$PSScriptRoot\n", host))
+		creds.Files["docker.cmd"] = []byte(fmt.Sprintf("set DOCKER_HOST=tcp://%s:2376\nset DOCKER_TLS_VERIFY=1\nset DOCKER_CERT_PATH=%%~dp0\n", host))
+		creds.Files["docker.fish"] = []byte(fmt.Sprintf("set -x DOCKER_HOST tcp://%s:2376\nset -x DOCKER_TLS_VERIFY 1\nset -x DOCKER_CERT_PATH (pwd)\n", host))
+	}
+	return creds, nil
+}
+
+func newCertificates(notAfter time.Time) ([]byte, []byte, []byte, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "carinatest-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "carinatest-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
@@ -0,0 +1,332 @@
+package carinatest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getcarina/libcarina"
+)
+
+// DefaultTransitionTime is how long FakeClusterAPI clusters spend creating, resizing or deleting
+const DefaultTransitionTime = time.Minute
+
+// FakeClusterAPI is an in-memory implementation of libcarina.ClusterAPI for unit tests which don't need HTTP.
+// Clusters report a transitional status, such as creating, for TransitionTime after each change before becoming active,
+// and deleted clusters are listed as deleting for TransitionTime before they are removed.
+type FakeClusterAPI struct {
+	// TransitionTime is how long a cluster takes to be created, resized or deleted, defaults to DefaultTransitionTime
+	TransitionTime time.Duration
+
+	// Now returns the current time, defaults to time.Now. Replace it to control the status transitions from a test.
+	Now func() time.Time
+
+	// Region is recorded in the credentials bundles
+	Region string
+
+	mu           sync.Mutex
+	clusters     []*fakeCluster
+	clusterTypes []*libcarina.ClusterType
+	credentials  map[string]*libcarina.CredentialsBundle
+}
+
+type fakeCluster struct {
+	libcarina.Cluster
+
+	// pendingNodes is the node count once a resize completes
+	pendingNodes int
+
+	// settlesAt is when the current transition completes
+	settlesAt time.Time
+}
+
+var _ libcarina.ClusterAPI = &FakeClusterAPI{}
+var _ libcarina.ClusterFinder = &FakeClusterAPI{}
+
+// NewFakeClusterAPI creates a FakeClusterAPI with Swarm and Kubernetes cluster types and no clusters
+func NewFakeClusterAPI() *FakeClusterAPI {
+	return &FakeClusterAPI{
+		TransitionTime: DefaultTransitionTime,
+		Now:            time.Now,
+		Region:         DefaultRegion,
+		clusterTypes:   defaultClusterTypes(),
+		credentials:    map[string]*libcarina.CredentialsBundle{},
+	}
+}
+
+// AddCluster adds a cluster, assigning an ID when it is empty and defaulting to an active Swarm cluster with one node
+func (f *FakeClusterAPI) AddCluster(cluster libcarina.Cluster) *libcarina.Cluster {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cluster.ID == "" {
+		cluster.ID = newUUID()
+	}
+	if cluster.Type == nil {
+		cluster.Type = f.clusterTypes[0]
+	}
+	if cluster.Nodes == 0 {
+		cluster.Nodes = 1
+	}
+	if cluster.Status == "" {
+		cluster.Status = StatusActive
+	}
+	f.clusters = append(f.clusters, &fakeCluster{Cluster: cluster})
+	return copyCluster(&cluster)
+}
+
+// List the current clusters
+func (f *FakeClusterAPI) List() ([]*libcarina.Cluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	clusters := make([]*libcarina.Cluster, len(f.clusters))
+	for i, cluster := range f.clusters {
+		clusters[i] = copyCluster(&cluster.Cluster)
+	}
+	return clusters, nil
+}
+
+// Get a cluster by its name or id
+func (f *FakeClusterAPI) Get(token string) (*libcarina.Cluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	cluster, err := f.find(token)
+	if err != nil {
+		return nil, err
+	}
+	return copyCluster(&cluster.Cluster), nil
+}
+
+// FindCluster looks up a single cluster by its name, id, id prefix or glob pattern
+func (f *FakeClusterAPI) FindCluster(token string) (*libcarina.Cluster, error) {
+	clusters, err := f.FindClusters(token)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(clusters) {
+	case 0:
+		return nil, fakeHTTPErr(http.StatusNotFound, fmt.Sprintf("Cluster %s not found", token))
+	case 1:
+		return clusters[0], nil
+	}
+	return nil, libcarina.AmbiguousClusterError{Token: token, Candidates: clusters}
+}
+
+// FindClusters lists the clusters identified by a name, id, id prefix or glob pattern
+func (f *FakeClusterAPI) FindClusters(token string) ([]*libcarina.Cluster, error) {
+	clusters, err := f.List()
+	if err != nil {
+		return nil, err
+	}
+	return libcarina.MatchClusters(clusters, token), nil
+}
+
+// Create a new cluster, which is creating for TransitionTime
+func (f *FakeClusterAPI) Create(clusterOpts *libcarina.CreateClusterOpts) (*libcarina.Cluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	if clusterOpts.Name == "" {
+		return nil, fakeHTTPErr(http.StatusBadRequest, "A cluster name is required")
+	}
+	for _, cluster := range f.clusters {
+		if strings.EqualFold(cluster.Name, clusterOpts.Name) {
+			return nil, fakeHTTPErr(http.StatusConflict, fmt.Sprintf("Cluster %s already exists", clusterOpts.Name))
+		}
+	}
+
+	var clusterType *libcarina.ClusterType
+	for _, t := range f.clusterTypes {
+		if t.ID == clusterOpts.ClusterTypeID && t.IsActive {
+			clusterType = t
+		}
+	}
+	if clusterType == nil {
+		return nil, fakeHTTPErr(http.StatusBadRequest, fmt.Sprintf("Cluster type %d is not available", clusterOpts.ClusterTypeID))
+	}
+
+	nodes := clusterOpts.Nodes
+	if nodes == 0 {
+		nodes = 1
+	}
+	cluster := &fakeCluster{
+		Cluster: libcarina.Cluster{
+			ID:     newUUID(),
+			Name:   clusterOpts.Name,
+			Type:   clusterType,
+			Nodes:  nodes,
+			Status: StatusCreating,
+		},
+		settlesAt: f.now().Add(f.transitionTime()),
+	}
+	f.clusters = append(f.clusters, cluster)
+	return copyCluster(&cluster.Cluster), nil
+}
+
+// Resize a cluster, which is resizing for TransitionTime before reporting the new node count
+func (f *FakeClusterAPI) Resize(token string, nodes int) (*libcarina.Cluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	cluster, err := f.find(token)
+	if err != nil {
+		return nil, err
+	}
+	if nodes < 1 {
+		return nil, fakeHTTPErr(http.StatusBadRequest, "node_count must be a positive integer")
+	}
+	if cluster.Status != StatusActive {
+		return nil, fakeHTTPErr(http.StatusConflict, fmt.Sprintf("Cluster %s is %s", cluster.Name, cluster.Status))
+	}
+
+	cluster.Status = StatusResizing
+	cluster.pendingNodes = nodes
+	cluster.settlesAt = f.now().Add(f.transitionTime())
+	return copyCluster(&cluster.Cluster), nil
+}
+
+// Delete a cluster, which is deleting for TransitionTime before it is removed
+func (f *FakeClusterAPI) Delete(token string) (*libcarina.Cluster, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	cluster, err := f.find(token)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster.Status = StatusDeleting
+	cluster.settlesAt = f.now().Add(f.transitionTime())
+	delete(f.credentials, cluster.ID)
+	return copyCluster(&cluster.Cluster), nil
+}
+
+// GetCredentials generates a credentials bundle for a cluster, returning the same bundle each time
+func (f *FakeClusterAPI) GetCredentials(token string) (*libcarina.CredentialsBundle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance()
+
+	cluster, err := f.find(token)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, ok := f.credentials[cluster.ID]
+	if !ok {
+		host := fmt.Sprintf("10.0.%d.%d", len(f.credentials)/250, len(f.credentials)%250+1)
+		creds, err = NewCredentialsBundle(libcarina.COE(cluster.Type.COE), host)
+		if err != nil {
+			return nil, err
+		}
+		err = creds.AppendEnv([]libcarina.EnvVar{
+			{Name: "CARINA_CLUSTER_NAME", Value: cluster.Name},
+			{Name: "CARINA_CLUSTER_ID", Value: cluster.ID},
+			{Name: "CARINA_REGION", Value: f.Region},
+		})
+		if err != nil {
+			return nil, err
+		}
+		f.credentials[cluster.ID] = creds
+	}
+
+	copied := libcarina.NewCredentialsBundle()
+	for fileName, contents := range creds.Files {
+		copied.Files[fileName] = append([]byte(nil), contents...)
+	}
+	return copied, nil
+}
+
+// ListClusterTypes returns the types of clusters which can be created
+func (f *FakeClusterAPI) ListClusterTypes() ([]*libcarina.ClusterType, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	types := make([]*libcarina.ClusterType, len(f.clusterTypes))
+	for i, t := range f.clusterTypes {
+		clusterType := *t
+		types[i] = &clusterType
+	}
+	return types, nil
+}
+
+// advance completes any transitions which are due, removing deleted clusters
+func (f *FakeClusterAPI) advance() {
+	now := f.now()
+	clusters := f.clusters[:0]
+	for _, cluster := range f.clusters {
+		if cluster.settlesAt.IsZero() || now.Before(cluster.settlesAt) {
+			clusters = append(clusters, cluster)
+			continue
+		}
+
+		switch cluster.Status {
+		case StatusDeleting:
+			continue
+		case StatusResizing:
+			cluster.Nodes = cluster.pendingNodes
+		}
+		cluster.Status = StatusActive
+		cluster.settlesAt = time.Time{}
+		clusters = append(clusters, cluster)
+	}
+	f.clusters = clusters
+}
+
+// find looks up a cluster by its exact id or name, as libcarina.CarinaClient does for single cluster operations
+func (f *FakeClusterAPI) find(token string) (*fakeCluster, error) {
+	var matches []*fakeCluster
+	for _, cluster := range f.clusters {
+		if strings.EqualFold(cluster.ID, token) {
+			return cluster, nil
+		}
+		if strings.EqualFold(cluster.Name, token) {
+			matches = append(matches, cluster)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fakeHTTPErr(http.StatusNotFound, fmt.Sprintf("Cluster %s not found", token))
+	case 1:
+		return matches[0], nil
+	}
+
+	candidates := make([]*libcarina.Cluster, len(matches))
+	for i, cluster := range matches {
+		candidates[i] = copyCluster(&cluster.Cluster)
+	}
+	return nil, libcarina.AmbiguousClusterError{Token: token, Candidates: candidates}
+}
+
+func (f *FakeClusterAPI) now() time.Time {
+	if f.Now == nil {
+		return time.Now()
+	}
+	return f.Now()
+}
+
+func (f *FakeClusterAPI) transitionTime() time.Duration {
+	if f.TransitionTime <= 0 {
+		return DefaultTransitionTime
+	}
+	return f.TransitionTime
+}
+
+func fakeHTTPErr(statusCode int, title string) error {
+	return libcarina.HTTPErr{
+		StatusCode: statusCode,
+		Status:     fmt.Sprintf("%d %s", statusCode, strings.ToUpper(http.StatusText(statusCode))),
+		Body:       fmt.Sprintf(`{"errors":[{"status":%d,"title":%q}]}`, statusCode, title),
+	}
+}
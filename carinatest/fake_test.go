@@ -0,0 +1,131 @@
+package carinatest_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getcarina/libcarina"
+	"github.com/getcarina/libcarina/carinatest"
+)
+
+func TestFakeClusterAPIStatusTransitions(t *testing.T) {
+	now := time.Now()
+	fake := carinatest.NewFakeClusterAPI()
+	fake.Now = func() time.Time { return now }
+
+	var api libcarina.ClusterAPI = fake
+	cluster, err := api.Create(&libcarina.CreateClusterOpts{Name: "mycluster", ClusterTypeID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusCreating {
+		t.Error("expected a new cluster to be creating, got", cluster.Status)
+	}
+
+	now = now.Add(carinatest.DefaultTransitionTime)
+	cluster, err = api.Get("mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusActive {
+		t.Error("expected the cluster to become active, got", cluster.Status)
+	}
+
+	cluster, err = api.Resize(cluster.ID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusResizing || cluster.Nodes != 1 {
+		t.Error("unexpected resizing cluster", cluster)
+	}
+	_, err = api.Resize(cluster.ID, 5)
+	assertStatusCode(t, err, http.StatusConflict)
+
+	now = now.Add(carinatest.DefaultTransitionTime)
+	cluster, _ = api.Get(cluster.ID)
+	if cluster.Status != carinatest.StatusActive || cluster.Nodes != 3 {
+		t.Error("expected the resize to complete", cluster)
+	}
+
+	creds, err := api.GetCredentials("mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClusterName() != "mycluster" || creds.COE() != libcarina.SwarmCOE {
+		t.Error("unexpected credentials", creds)
+	}
+
+	cluster, err = api.Delete("mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusDeleting {
+		t.Error("expected the cluster to be deleting, got", cluster.Status)
+	}
+	if clusters, _ := api.List(); len(clusters) != 1 {
+		t.Error("expected the cluster to be listed until the delete completes")
+	}
+
+	now = now.Add(carinatest.DefaultTransitionTime)
+	if clusters, _ := api.List(); len(clusters) != 0 {
+		t.Error("expected the cluster to be removed")
+	}
+	_, err = api.Get("mycluster")
+	assertStatusCode(t, err, http.StatusNotFound)
+}
+
+func TestFakeCredentialsQuoting(t *testing.T) {
+	api := carinatest.NewFakeClusterAPI()
+	name := `my "ci" cluster's $(rm -rf)`
+	api.AddCluster(libcarina.Cluster{Name: name})
+
+	creds, err := api.GetCredentials(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClusterName() != name {
+		t.Errorf("expected the cluster name %q, got %q", name, creds.ClusterName())
+	}
+	if _, err = creds.DockerEnv(); err != nil {
+		t.Error(err)
+	}
+	for _, fileName := range []string{"docker.env", "docker.ps1", "docker.cmd", "docker.fish"} {
+		if !strings.Contains(string(creds.Files[fileName]), "CARINA_CLUSTER_NAME") {
+			t.Errorf("expected %s to set CARINA_CLUSTER_NAME, got %s", fileName, creds.Files[fileName])
+		}
+	}
+}
+
+func TestFakeClusterAPIErrors(t *testing.T) {
+	fake := carinatest.NewFakeClusterAPI()
+	fake.AddCluster(libcarina.Cluster{Name: "dup"})
+	fake.AddCluster(libcarina.Cluster{Name: "dup"})
+
+	if _, err := fake.Get("dup"); err == nil {
+		t.Error("expected an error for an ambiguous name")
+	} else if ambiguous, ok := err.(libcarina.AmbiguousClusterError); !ok || len(ambiguous.Candidates) != 2 {
+		t.Error("expected an AmbiguousClusterError listing both clusters, got", err)
+	}
+
+	if clusters, _ := fake.FindClusters("d*"); len(clusters) != 2 {
+		t.Error("expected both clusters to match the pattern, got", clusters)
+	}
+	if _, err := fake.FindCluster("d*"); err == nil {
+		t.Error("expected an error for an ambiguous pattern")
+	}
+	_, err := fake.Delete("du*")
+	assertStatusCode(t, err, http.StatusNotFound)
+
+	_, err = fake.Create(&libcarina.CreateClusterOpts{Name: "new", ClusterTypeID: 42})
+	assertStatusCode(t, err, http.StatusBadRequest)
+
+	_, err = fake.Create(&libcarina.CreateClusterOpts{Name: "DUP", ClusterTypeID: 1})
+	assertStatusCode(t, err, http.StatusConflict)
+
+	types, err := fake.ListClusterTypes()
+	if err != nil || len(types) != 2 {
+		t.Error("expected the default cluster types", types, err)
+	}
+}
@@ -0,0 +1,111 @@
+package carinatest
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/getcarina/libcarina"
+)
+
+// Fault changes how matching requests to the fake services are handled, e.g. to add latency or fail with an error
+type Fault struct {
+	// Method of the requests to match, matches every method when empty
+	Method string
+
+	// Path of the requests to match, as a path.Match pattern such as /clusters/*, matches every path when empty
+	Path string
+
+	// Latency delays the response
+	Latency time.Duration
+
+	// StatusCode fails the request with this status instead of handling it
+	StatusCode int
+
+	// Body of the error response, defaults to a Carina error for StatusCode
+	Body string
+
+	// Times limits how many requests the fault applies to, applies to every matching request when zero
+	Times int
+
+	applied int
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	if f.Times > 0 && f.applied >= f.Times {
+		return false
+	}
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+	if f.Path != "" {
+		if ok, _ := path.Match(f.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Inject adds a fault. The first fault which matches a request is applied to it.
+func (s *Server) Inject(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes every injected fault
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// wrap records each request and applies any matching fault before calling next
+func (s *Server) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		var fault Fault
+		for _, f := range s.faults {
+			if f.matches(r) {
+				f.applied++
+				fault = *f
+				break
+			}
+		}
+		s.mu.Unlock()
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if fault.StatusCode != 0 {
+			if fault.Body != "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(fault.StatusCode)
+				w.Write([]byte(fault.Body))
+				return
+			}
+			writeError(w, fault.StatusCode, "carinatest.injected-fault", http.StatusText(fault.StatusCode))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, statusCode int, code string, title string) {
+	writeJSON(w, statusCode, libcarina.CarinaGenericErrorResponse{
+		Errors: []libcarina.CarinaError{{
+			Code:      code,
+			Detail:    title,
+			RequestID: newUUID(),
+			Status:    statusCode,
+			Title:     title,
+		}},
+	})
+}
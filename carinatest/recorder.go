@@ -0,0 +1,329 @@
+package carinatest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Redacted replaces tokens, API keys and passwords in recorded interactions
+const Redacted = "REDACTED"
+
+// RecorderMode selects whether a Recorder captures real interactions or replays a cassette
+type RecorderMode int
+
+const (
+	// ModeReplay serves responses from the cassette and fails requests which were not recorded
+	ModeReplay RecorderMode = iota
+
+	// ModeRecord sends requests to the real services and records them to the cassette
+	ModeRecord
+)
+
+// sensitiveHeaders are replaced with Redacted when recorded
+var sensitiveHeaders = []string{"X-Auth-Token", "X-Subject-Token", "Authorization"}
+
+// sensitiveJSONFields matches credentials in request bodies, e.g. the apiKey sent to the identity service
+var sensitiveJSONFields = regexp.MustCompile(`("(?:apiKey|password|api_key)"\s*:\s*")[^"]*(")`)
+
+// Cassette is a sequence of recorded HTTP interactions
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the scrubbed copy of a request
+type RecordedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// RecordedResponse is the scrubbed copy of a response
+type RecordedResponse struct {
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+// Recorder is an http.RoundTripper which records interactions to a cassette file, or replays them.
+// Use it as libcarina.ClientOpts.Transport. Tokens, API keys and passwords are replaced with Redacted,
+// both when recording and when matching requests during replay, so cassettes are safe to commit.
+// Note that credentials zips are recorded as-is, and so contain the private key of the recorded cluster.
+type Recorder struct {
+	// Mode selects recording or replay
+	Mode RecorderMode
+
+	// Path of the cassette file
+	Path string
+
+	// Transport sends requests when recording, defaults to http.DefaultTransport
+	Transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+	secrets  []string
+}
+
+// NewRecorder creates a Recorder for the cassette at path, loading it when replaying
+func NewRecorder(path string, mode RecorderMode) (*Recorder, error) {
+	r := &Recorder{Mode: mode, Path: path, cassette: &Cassette{}}
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("carinatest: unable to read cassette %s: %s", path, err)
+	}
+	if err = json.Unmarshal(contents, r.cassette); err != nil {
+		return nil, fmt.Errorf("carinatest: invalid cassette %s: %s", path, err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// RoundTrip records or replays a single request
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// RoundTrip must not modify the caller's request, so the body is replaced on a copy
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range sensitiveHeaders {
+		for _, value := range req.Header[http.CanonicalHeaderKey(name)] {
+			r.addSecret(strings.TrimPrefix(value, "Bearer "))
+		}
+	}
+
+	if r.Mode == ModeRecord {
+		return r.record(req, reqBody)
+	}
+	return r.replay(req, reqBody)
+}
+
+func (r *Recorder) record(req *http.Request, reqBody []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	for _, tokenID := range identityTokenIDs(respBody) {
+		r.addSecret(tokenID)
+	}
+
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    r.scrub(req.URL.String()),
+			Header: r.scrubHeader(req.Header),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     r.scrubHeader(resp.Header),
+		},
+	}
+	interaction.Request.Body, interaction.Request.BodyEncoding = r.encodeBody(reqBody)
+	interaction.Response.Body, interaction.Response.BodyEncoding = r.encodeBody(respBody)
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, reqBody []byte) (*http.Response, error) {
+	path := r.scrub(req.URL.Path)
+	query := r.scrub(req.URL.RawQuery)
+	body, _ := r.encodeBody(reqBody)
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !interaction.Request.matches(req.Method, path, query, body) {
+			continue
+		}
+		r.used[i] = true
+
+		respBody, err := interaction.Response.decodeBody()
+		if err != nil {
+			return nil, fmt.Errorf("carinatest: invalid response body in cassette %s: %s", r.Path, err)
+		}
+
+		header := http.Header{}
+		for name, values := range interaction.Response.Header {
+			header[name] = append([]string(nil), values...)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	}
+
+	if query != "" {
+		path += "?" + query
+	}
+	return nil, fmt.Errorf("carinatest: unrecorded request %s %s with body %q in cassette %s", req.Method, path, body, r.Path)
+}
+
+// Save writes the recorded interactions to the cassette file, and must be called after recording
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Mode != ModeRecord {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.Path, append(contents, '\n'), 0644)
+}
+
+// Unused returns the recorded interactions which were not replayed, e.g. to check that a test made every expected request
+func (r *Recorder) Unused() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []*Interaction
+	for i, interaction := range r.cassette.Interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// identityTokenIDs returns the tokens issued by the identity service, found at access.token.id or token.id in a JSON body
+func identityTokenIDs(body []byte) []string {
+	var identityResp struct {
+		Access struct {
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+		} `json:"access"`
+		Token struct {
+			ID string `json:"id"`
+		} `json:"token"`
+	}
+	// Fields of an unexpected type are skipped, so the error is ignored and whatever was decoded is used
+	json.Unmarshal(body, &identityResp)
+	return []string{identityResp.Access.Token.ID, identityResp.Token.ID}
+}
+
+func (r *Recorder) addSecret(secret string) {
+	if secret == "" || secret == Redacted {
+		return
+	}
+	for _, s := range r.secrets {
+		if s == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+}
+
+// scrub replaces credentials and any known tokens in s
+func (r *Recorder) scrub(s string) string {
+	s = sensitiveJSONFields.ReplaceAllString(s, "${1}"+Redacted+"${2}")
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, Redacted, -1)
+	}
+	return s
+}
+
+func (r *Recorder) scrubHeader(header http.Header) http.Header {
+	scrubbed := http.Header{}
+	for name, values := range header {
+		for _, value := range values {
+			scrubbed.Add(name, r.scrub(value))
+		}
+	}
+	for _, name := range sensitiveHeaders {
+		if scrubbed.Get(name) != "" {
+			scrubbed.Set(name, Redacted)
+		}
+	}
+	return scrubbed
+}
+
+// encodeBody scrubs text bodies, and base64 encodes binary bodies such as credentials zips
+func (r *Recorder) encodeBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return r.scrub(string(body)), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// matches compares the scrubbed request, ignoring the order of the query parameters
+func (req RecordedRequest) matches(method string, path string, query string, body string) bool {
+	if req.Method != method || req.Body != body {
+		return false
+	}
+	recordedURL, err := url.Parse(req.URL)
+	if err != nil || recordedURL.Path != path {
+		return false
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(recordedURL.Query(), values)
+}
+
+func (resp RecordedResponse) decodeBody() ([]byte, error) {
+	if resp.BodyEncoding == "base64" {
+		return base64.StdEncoding.DecodeString(resp.Body)
+	}
+	return []byte(resp.Body), nil
+}
@@ -0,0 +1,184 @@
+package carinatest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getcarina/libcarina"
+	"github.com/getcarina/libcarina/carinatest"
+)
+
+func TestRecorder(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	server := carinatest.NewServer()
+	cluster := server.AddCluster(libcarina.Cluster{Name: "mycluster"})
+
+	exercise := func(recorder *carinatest.Recorder) (*libcarina.CarinaClient, *libcarina.CredentialsBundle) {
+		client, err := libcarina.NewClientWithOptions(server.Username, server.APIKey, server.Region, server.IdentityEndpoint(), "", "",
+			&libcarina.ClientOpts{Transport: recorder})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clusters, err := client.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clusters) != 1 || clusters[0].ID != cluster.ID {
+			t.Error("unexpected clusters", clusters)
+		}
+		creds, err := client.GetCredentials(cluster.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, creds
+	}
+
+	recorder, err := carinatest.NewRecorder(cassette, carinatest.ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordingClient, recorded := exercise(recorder)
+	if err = recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	contents, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{server.APIKey, recordingClient.Token} {
+		if strings.Contains(string(contents), secret) {
+			t.Error("expected the cassette to be scrubbed of", secret)
+		}
+	}
+
+	replayer, err := carinatest.NewRecorder(cassette, carinatest.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, replayed := exercise(replayer)
+	if string(replayed.GetCert()) != string(recorded.GetCert()) {
+		t.Error("expected the recorded credentials to be replayed")
+	}
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Error("expected every interaction to be replayed, unused:", len(unused))
+	}
+
+	client, err := libcarina.NewClientWithOptions(server.Username, server.APIKey, server.Region, server.IdentityEndpoint(), "", "",
+		&libcarina.ClientOpts{Transport: replayer})
+	if err == nil {
+		_, err = client.List()
+	}
+	if err == nil || !strings.Contains(err.Error(), "unrecorded request") {
+		t.Error("expected an unrecorded request to fail, got", err)
+	}
+}
+
+func TestRecorderMatchesQuery(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	server := carinatest.NewServer()
+	defer server.Close()
+	first := server.AddCluster(libcarina.Cluster{Name: "first"})
+	server.AddCluster(libcarina.Cluster{Name: "second"})
+
+	newClient := func(recorder *carinatest.Recorder) *libcarina.CarinaClient {
+		client, err := libcarina.NewClientWithOptions(server.Username, server.APIKey, server.Region, server.IdentityEndpoint(), "", "",
+			&libcarina.ClientOpts{Transport: recorder})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	recorder, err := carinatest.NewRecorder(cassette, carinatest.ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(recorder)
+	for _, marker := range []string{"", first.ID} {
+		if _, err = client.ListWithOptions(&libcarina.ListClustersOpts{Limit: 1, Marker: marker}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay the pages in the opposite order, each must get the response recorded for its marker
+	replayer, err := carinatest.NewRecorder(cassette, carinatest.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = newClient(replayer)
+	page, err := client.ListWithOptions(&libcarina.ListClustersOpts{Limit: 1, Marker: first.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Clusters) != 1 || page.Clusters[0].Name != "second" {
+		t.Error("expected the page after the marker, got", page.Clusters)
+	}
+
+	_, err = client.ListWithOptions(&libcarina.ListClustersOpts{Limit: 2})
+	if err == nil || !strings.Contains(err.Error(), "unrecorded request GET /clusters?limit=2") {
+		t.Error("expected a request with a different query to be unrecorded, got", err)
+	}
+}
+
+func TestRecorderDoesNotModifyRequest(t *testing.T) {
+	server := carinatest.NewServer()
+	defer server.Close()
+
+	recorder, err := carinatest.NewRecorder(filepath.Join(t.TempDir(), "cassette.json"), carinatest.ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", server.IdentityEndpoint()+"tokens", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := req.Body
+	if _, err = recorder.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Body != body {
+		t.Error("expected the caller's request body to be left alone")
+	}
+}
+
+func TestRecorderScrubsNestedIdentityToken(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// The tenant precedes the id, as in Rackspace identity responses
+		fmt.Fprintf(w, `{"access": {"token": {"expires": "2026-10-19T00:00:00.000Z", "tenant": {"id": "123456", "name": "123456"}, `+
+			`"RAX-AUTH:authenticatedBy": ["APIKEY"], "id": "%s"}, "user": {"id": "u", "name": "user"}}}`, token)
+	}))
+	defer identity.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := carinatest.NewRecorder(cassette, carinatest.ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", identity.URL+"/v2.0/tokens", strings.NewReader(`{}`))
+	if _, err = recorder.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if err = recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), token) {
+		t.Error("expected the identity token to be scrubbed from the cassette")
+	}
+}
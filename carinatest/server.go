@@ -0,0 +1,287 @@
+// Package carinatest provides an in-process fake of the Carina API and the Rackspace identity service,
+// so that code which uses libcarina can be tested without the real service.
+package carinatest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getcarina/libcarina"
+)
+
+const (
+	// DefaultUsername is the username accepted by the fake identity service
+	DefaultUsername = "carinatest-user"
+
+	// DefaultAPIKey is the API key accepted by the fake identity service
+	DefaultAPIKey = "carinatest-apikey"
+
+	// DefaultRegion is the region of the Carina endpoint in the service catalog
+	DefaultRegion = "DFW"
+
+	// DefaultTokenTTL is how long tokens issued by the fake identity service are valid
+	DefaultTokenTTL = 24 * time.Hour
+)
+
+// Cluster statuses reported by the fake Carina API
+const (
+	StatusCreating = "creating"
+	StatusActive   = "active"
+	StatusResizing = "resizing"
+	StatusDeleting = "deleting"
+)
+
+// Server is a stateful fake of the Carina API and the identity service used to authenticate to it.
+// Clusters created through the API start out creating and become active after they are next fetched by ID,
+// and resized clusters behave the same way, so that callers which poll for status can be exercised.
+type Server struct {
+	// Identity is the fake Rackspace identity service
+	Identity *httptest.Server
+
+	// Carina is the fake Carina API
+	Carina *httptest.Server
+
+	// Username and APIKey are the credentials accepted by the identity service
+	Username string
+	APIKey   string
+
+	// Region of the Carina endpoint in the service catalog
+	Region string
+
+	// TokenTTL is how long new tokens are valid, defaults to DefaultTokenTTL
+	TokenTTL time.Duration
+
+	mu           sync.Mutex
+	clusters     []*libcarina.Cluster
+	clusterTypes []*libcarina.ClusterType
+	credentials  map[string]*libcarina.CredentialsBundle
+	tasks        map[string][]*Task
+	tokens       map[string]time.Time
+	faults       []*Fault
+	requests     []string
+}
+
+// Task is a task submitted to a cluster, e.g. a resize
+type Task struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Status string                 `json:"status"`
+	Input  map[string]interface{} `json:"input"`
+}
+
+// NewServer starts a fake Carina API and identity service, with Swarm and Kubernetes cluster types and no clusters.
+// Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		Username:     DefaultUsername,
+		APIKey:       DefaultAPIKey,
+		Region:       DefaultRegion,
+		TokenTTL:     DefaultTokenTTL,
+		clusterTypes: defaultClusterTypes(),
+		credentials:  map[string]*libcarina.CredentialsBundle{},
+		tasks:        map[string][]*Task{},
+		tokens:       map[string]time.Time{},
+	}
+	s.Identity = httptest.NewServer(s.wrap(http.HandlerFunc(s.serveIdentity)))
+	s.Carina = httptest.NewServer(s.wrap(http.HandlerFunc(s.serveCarina)))
+	return s
+}
+
+// Close shuts down the fake services
+func (s *Server) Close() {
+	s.Carina.Close()
+	s.Identity.Close()
+}
+
+// IdentityEndpoint is the URL to use as the authEndpointOverride for libcarina.NewClient
+func (s *Server) IdentityEndpoint() string {
+	return s.Identity.URL + "/v2.0/"
+}
+
+// NewClient creates a libcarina client authenticated against the fake services
+func (s *Server) NewClient() (*libcarina.CarinaClient, error) {
+	return libcarina.NewClient(s.Username, s.APIKey, s.Region, s.IdentityEndpoint(), "", "")
+}
+
+// AddCluster adds a cluster, assigning an ID when it is empty and defaulting to an active Swarm cluster with one node
+func (s *Server) AddCluster(cluster libcarina.Cluster) *libcarina.Cluster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cluster.ID == "" {
+		cluster.ID = newUUID()
+	}
+	if cluster.Type == nil {
+		cluster.Type = s.clusterTypes[0]
+	}
+	if cluster.Nodes == 0 {
+		cluster.Nodes = 1
+	}
+	if cluster.Status == "" {
+		cluster.Status = StatusActive
+	}
+	s.clusters = append(s.clusters, &cluster)
+	return copyCluster(&cluster)
+}
+
+// AddClusterType adds a cluster type which can be used to create clusters
+func (s *Server) AddClusterType(clusterType libcarina.ClusterType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusterTypes = append(s.clusterTypes, &clusterType)
+}
+
+// Clusters returns a copy of the current clusters
+func (s *Server) Clusters() []*libcarina.Cluster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clusters := make([]*libcarina.Cluster, len(s.clusters))
+	for i, cluster := range s.clusters {
+		clusters[i] = copyCluster(cluster)
+	}
+	return clusters
+}
+
+// SetCredentials replaces the credentials bundle served for a cluster, instead of generating one
+func (s *Server) SetCredentials(clusterID string, creds *libcarina.CredentialsBundle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[clusterID] = creds
+}
+
+// Tasks returns the tasks submitted to a cluster
+func (s *Server) Tasks(clusterID string) []*Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Task(nil), s.tasks[clusterID]...)
+}
+
+// Requests returns each request received so far, e.g. "GET /clusters" or "POST /v2.0/tokens"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// ExpireTokens invalidates every token issued so far, so that clients must authenticate again
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]time.Time{}
+}
+
+// IssueToken creates a valid token, e.g. to use as the cached token for libcarina.NewClient
+func (s *Server) IssueToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueToken()
+}
+
+func (s *Server) issueToken() string {
+	ttl := s.TokenTTL
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	token := newUUID()
+	s.tokens[token] = time.Now().Add(ttl)
+	return token
+}
+
+func (s *Server) validToken(token string) bool {
+	expires, ok := s.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+func (s *Server) serveIdentity(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/v2.0/tokens" && r.Method == "POST" {
+		var auth struct {
+			Auth struct {
+				APIKeyCredentials struct {
+					Username string `json:"username"`
+					APIKey   string `json:"apiKey"`
+				} `json:"RAX-KSKEY:apiKeyCredentials"`
+			} `json:"auth"`
+		}
+		json.NewDecoder(r.Body).Decode(&auth)
+		creds := auth.Auth.APIKeyCredentials
+		if creds.Username != s.Username || creds.APIKey != s.APIKey {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"unauthorized": map[string]interface{}{"code": http.StatusUnauthorized, "message": "Username or api key is invalid."},
+			})
+			return
+		}
+
+		token := s.issueToken()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access": map[string]interface{}{
+				"token": map[string]interface{}{
+					"id":      token,
+					"expires": s.tokens[token].UTC().Format(time.RFC3339),
+					"tenant":  map[string]string{"id": "carinatest-tenant", "name": "carinatest-tenant"},
+				},
+				"user": map[string]string{"id": "carinatest-userid", "name": s.Username},
+				"serviceCatalog": []interface{}{
+					map[string]interface{}{
+						"name": "cloudContainer",
+						"type": libcarina.CarinaEndpointType,
+						"endpoints": []interface{}{
+							map[string]string{"tenantId": "carinatest-tenant", "publicURL": s.Carina.URL, "region": s.Region},
+						},
+					},
+				},
+			},
+		})
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v2.0/tokens/") && (r.Method == "HEAD" || r.Method == "GET") {
+		if !s.validToken(strings.TrimPrefix(r.URL.Path, "/v2.0/tokens/")) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func defaultClusterTypes() []*libcarina.ClusterType {
+	return []*libcarina.ClusterType{
+		{ID: 1, Name: "Docker Swarm", IsActive: true, COE: string(libcarina.SwarmCOE), HostType: "lxc"},
+		{ID: 2, Name: "Kubernetes", IsActive: true, COE: string(libcarina.KubernetesCOE), HostType: "vm"},
+	}
+}
+
+func copyCluster(cluster *libcarina.Cluster) *libcarina.Cluster {
+	c := *cluster
+	if c.Type != nil {
+		clusterType := *c.Type
+		c.Type = &clusterType
+	}
+	return &c
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
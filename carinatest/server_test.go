@@ -0,0 +1,227 @@
+package carinatest_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/getcarina/libcarina"
+	"github.com/getcarina/libcarina/carinatest"
+	"github.com/pkg/errors"
+)
+
+func newTestServer(t *testing.T) (*carinatest.Server, *libcarina.CarinaClient) {
+	server := carinatest.NewServer()
+	t.Cleanup(server.Close)
+
+	client, err := server.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func assertStatusCode(t *testing.T, err error, statusCode int) {
+	httpErr, ok := errors.Cause(err).(libcarina.HTTPErr)
+	if !ok || httpErr.StatusCode != statusCode {
+		t.Errorf("expected a %d error, got %v", statusCode, err)
+	}
+}
+
+func TestClusterLifecycle(t *testing.T) {
+	server, client := newTestServer(t)
+
+	types, err := client.ListClusterTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 {
+		t.Fatal("expected the default cluster types, got", len(types))
+	}
+
+	cluster, err := client.Create(&libcarina.CreateClusterOpts{Name: "mycluster", ClusterTypeID: types[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusCreating || cluster.Nodes != 1 {
+		t.Error("unexpected new cluster", cluster)
+	}
+
+	_, err = client.Create(&libcarina.CreateClusterOpts{Name: "mycluster", ClusterTypeID: types[0].ID})
+	assertStatusCode(t, err, http.StatusConflict)
+
+	cluster, err = client.Get("mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusCreating {
+		t.Error("expected the cluster to be creating on the first read, got", cluster.Status)
+	}
+	cluster, err = client.Get(cluster.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Status != carinatest.StatusActive {
+		t.Error("expected the cluster to become active, got", cluster.Status)
+	}
+
+	cluster, err = client.Resize("mycluster", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Nodes != 3 || cluster.Status != carinatest.StatusResizing {
+		t.Error("unexpected resized cluster", cluster)
+	}
+	if tasks := server.Tasks(cluster.ID); len(tasks) != 1 || tasks[0].Type != "resize" {
+		t.Error("expected a resize task", tasks)
+	}
+
+	creds, err := client.GetCredentials("mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.COE() != libcarina.SwarmCOE || creds.ClusterName() != "mycluster" {
+		t.Error("unexpected credentials", creds)
+	}
+	if _, err = creds.ClientCertificate(); err != nil {
+		t.Error(err)
+	}
+
+	deleted, err := client.Delete("mycluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Status != carinatest.StatusDeleting {
+		t.Error("unexpected deleted cluster", deleted)
+	}
+	if len(server.Clusters()) != 0 {
+		t.Error("expected the cluster to be removed")
+	}
+	_, err = client.Get(cluster.ID)
+	assertStatusCode(t, err, http.StatusNotFound)
+}
+
+func TestKubernetesCredentials(t *testing.T) {
+	server, client := newTestServer(t)
+	server.AddCluster(libcarina.Cluster{
+		Name: "kube",
+		Type: &libcarina.ClusterType{ID: 2, Name: "Kubernetes", COE: "kubernetes"},
+	})
+
+	creds, err := client.GetCredentials("kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.COE() != libcarina.KubernetesCOE {
+		t.Error("expected a kubernetes bundle, got", creds.COE())
+	}
+	if _, err = creds.ParseHost(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	server, client := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		server.AddCluster(libcarina.Cluster{Name: fmt.Sprintf("cluster-%d", i)})
+	}
+	server.AddCluster(libcarina.Cluster{Name: "creating", Status: carinatest.StatusCreating})
+
+	page, err := client.ListWithOptions(&libcarina.ListClustersOpts{Limit: 2, Status: carinatest.StatusActive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Clusters) != 2 || page.NextMarker != page.Clusters[1].ID {
+		t.Fatal("unexpected first page", page.Clusters, page.NextMarker)
+	}
+
+	var names []string
+	it := client.IterateClusters(&libcarina.ListClustersOpts{Limit: 2, Status: carinatest.StatusActive})
+	for it.Next() {
+		names = append(names, it.Cluster().Name)
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if fmt.Sprint(names) != "[cluster-0 cluster-1 cluster-2 cluster-3 cluster-4]" {
+		t.Error("expected every active cluster, got", names)
+	}
+
+	_, err = client.ListWithOptions(&libcarina.ListClustersOpts{Marker: "missing"})
+	assertStatusCode(t, err, http.StatusBadRequest)
+}
+
+func TestInvalidAPIKey(t *testing.T) {
+	server := carinatest.NewServer()
+	defer server.Close()
+
+	_, err := libcarina.NewClient(server.Username, "wrong", server.Region, server.IdentityEndpoint(), "", "")
+	if err == nil {
+		t.Error("expected authentication to fail")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	server, client := newTestServer(t)
+
+	server.ExpireTokens()
+	_, err := client.List()
+	assertStatusCode(t, err, http.StatusUnauthorized)
+
+	// Reusing the expired token should fall back to authenticating with the API key
+	client, err = libcarina.NewClient(server.Username, server.APIKey, server.Region, server.IdentityEndpoint(), client.Token, client.Endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.List(); err != nil {
+		t.Error(err)
+	}
+
+	token := server.IssueToken()
+	client, err = libcarina.NewClient(server.Username, "", server.Region, server.IdentityEndpoint(), token, server.Carina.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Token != token {
+		t.Error("expected the cached token to be used")
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	server, client := newTestServer(t)
+
+	server.Inject(carinatest.Fault{Method: "GET", Path: "/clusters", StatusCode: http.StatusServiceUnavailable, Times: 1})
+	_, err := client.List()
+	assertStatusCode(t, err, http.StatusServiceUnavailable)
+	if _, err = client.List(); err != nil {
+		t.Error("expected the fault to apply once, got", err)
+	}
+
+	server.Inject(carinatest.Fault{Path: "/cluster_types", Latency: time.Second})
+	client.Client.Timeout = 50 * time.Millisecond
+	if _, err = client.ListClusterTypes(); err == nil {
+		t.Error("expected the request to time out")
+	}
+
+	server.ClearFaults()
+	if _, err = client.ListClusterTypes(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnsupportedAPIVersion(t *testing.T) {
+	server, client := newTestServer(t)
+
+	req, _ := http.NewRequest("GET", server.Carina.URL+"/clusters", nil)
+	req.Header.Set("X-Auth-Token", client.Token)
+	req.Header.Set("API-Version", "rax:container 2.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Error("expected 406, got", resp.StatusCode)
+	}
+}
@@ -0,0 +1,41 @@
+package libcarina
+
+// ClusterAPI is the set of cluster operations provided by CarinaClient.
+// Depend on it instead of *CarinaClient so that the client can be replaced by a fake, such as carinatest.FakeClusterAPI,
+// or wrapped to add behavior such as caching or retries.
+type ClusterAPI interface {
+	// List the current clusters
+	List() ([]*Cluster, error)
+
+	// Get a cluster by its name or id
+	Get(token string) (*Cluster, error)
+
+	// Create a new cluster
+	Create(clusterOpts *CreateClusterOpts) (*Cluster, error)
+
+	// Resize a cluster to the specified number of nodes
+	Resize(token string, nodes int) (*Cluster, error)
+
+	// Delete a cluster by its name or id
+	Delete(token string) (*Cluster, error)
+
+	// GetCredentials downloads the credentials bundle for a cluster by its name or id
+	GetCredentials(token string) (*CredentialsBundle, error)
+
+	// ListClusterTypes returns the types of clusters which can be created
+	ListClusterTypes() ([]*ClusterType, error)
+}
+
+var _ ClusterAPI = &CarinaClient{}
+
+// ClusterFinder looks up clusters by id prefix or glob pattern, in addition to their exact name or id, see MatchClusters.
+// It is separate from ClusterAPI so that existing implementations of ClusterAPI are unaffected.
+type ClusterFinder interface {
+	// FindCluster looks up a single cluster, returning an AmbiguousClusterError when more than one cluster matches
+	FindCluster(token string) (*Cluster, error)
+
+	// FindClusters lists every matching cluster, for operations on several clusters
+	FindClusters(token string) ([]*Cluster, error)
+}
+
+var _ ClusterFinder = &CarinaClient{}
@@ -0,0 +1,23 @@
+package libcarina
+
+// COE identifies the container orchestration engine used by a cluster
+type COE string
+
+const (
+	// UnknownCOE is used when the container orchestration engine cannot be determined
+	UnknownCOE COE = "unknown"
+
+	// SwarmCOE identifies a Docker Swarm cluster
+	SwarmCOE COE = "swarm"
+
+	// KubernetesCOE identifies a Kubernetes cluster
+	KubernetesCOE COE = "kubernetes"
+
+	// MesosCOE identifies a Mesos cluster
+	MesosCOE COE = "mesos"
+)
+
+// String returns the name of the COE, e.g. swarm
+func (coe COE) String() string {
+	return string(coe)
+}
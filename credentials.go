@@ -1,12 +1,14 @@
 package libcarina
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,6 +22,12 @@ const verifyCredentialsTimeout = 2 * time.Second
 type CredentialsBundle struct {
 	Files map[string][]byte
 	Err   error
+
+	// RawZip is the credentials zip exactly as returned by Carina, see GetCredentialsOpts.KeepRawZip
+	RawZip []byte
+
+	// Proxy routes connections to the cluster through a proxy, see Verify, DialContext and HTTPClient
+	Proxy *ProxyConfig
 }
 
 // NewCredentialsBundle initializes an empty credentials bundle
@@ -29,8 +37,30 @@ func NewCredentialsBundle() *CredentialsBundle {
 	}
 }
 
-// LoadCredentialsBundle loads a credentials bundle from the filesystem
+// LoadCredentialsBundle loads a credentials bundle from the filesystem.
+// Use LoadCredentialsBundleWithKey to load a bundle saved with SaveSealed.
 func LoadCredentialsBundle(credentialsPath string) *CredentialsBundle {
+	return LoadCredentialsBundleWithKey(credentialsPath, nil)
+}
+
+// LoadCredentialsBundleWithKey loads a credentials bundle from the filesystem, decrypting it with key when it was saved with SaveSealed.
+// The path is either a bundle directory or a SealedCredentialsFileName file. The key may be nil for an unencrypted bundle.
+func LoadCredentialsBundleWithKey(credentialsPath string, key *CredentialsKey) *CredentialsBundle {
+	sealedPath := credentialsPath
+	if fi, err := os.Stat(credentialsPath); err == nil && fi.IsDir() {
+		sealedPath = filepath.Join(credentialsPath, SealedCredentialsFileName)
+	}
+
+	if sealed, err := ioutil.ReadFile(sealedPath); err == nil && IsSealed(sealed) {
+		creds, err := UnsealCredentialsBundle(sealed, key)
+		if err != nil {
+			return &CredentialsBundle{
+				Err: errors.Wrapf(err, "Cannot load %s", sealedPath),
+			}
+		}
+		return creds
+	}
+
 	files, err := ioutil.ReadDir(credentialsPath)
 	if err != nil {
 		return &CredentialsBundle{
@@ -52,6 +82,80 @@ func LoadCredentialsBundle(credentialsPath string) *CredentialsBundle {
 	return creds
 }
 
+// Save writes the credentials bundle to a directory on the filesystem,
+// removing any encrypted bundle previously saved there with SaveSealed.
+func (creds *CredentialsBundle) Save(credentialsPath string) error {
+	if creds.Err != nil {
+		return creds.Err
+	}
+
+	err := os.MkdirAll(credentialsPath, 0700)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", credentialsPath)
+	}
+
+	for fileName, contents := range creds.Files {
+		filePath := filepath.Join(credentialsPath, fileName)
+		err = ioutil.WriteFile(filePath, contents, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to write %s", filePath)
+		}
+	}
+
+	if _, ok := creds.Files[SealedCredentialsFileName]; !ok {
+		sealedPath := filepath.Join(credentialsPath, SealedCredentialsFileName)
+		err = os.Remove(sealedPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Unable to remove %s", sealedPath)
+		}
+	}
+	return nil
+}
+
+// SaveSealed encrypts the credentials bundle into a single file in a directory on the filesystem, see SealedCredentialsFileName.
+// Unencrypted bundle files previously saved to the directory, those in the bundle or KnownCredentialsFiles, are removed
+// so that no plaintext keys are left behind. Other files in the directory are left alone.
+func (creds *CredentialsBundle) SaveSealed(credentialsPath string, key *CredentialsKey) error {
+	if creds.Err != nil {
+		return creds.Err
+	}
+
+	sealed, err := creds.Seal(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(credentialsPath, 0700)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", credentialsPath)
+	}
+
+	sealedPath := filepath.Join(credentialsPath, SealedCredentialsFileName)
+	err = ioutil.WriteFile(sealedPath, sealed, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write %s", sealedPath)
+	}
+
+	fileNames := append([]string{}, KnownCredentialsFiles...)
+	for fileName := range creds.Files {
+		fileNames = append(fileNames, fileName)
+	}
+	for _, fileName := range fileNames {
+		if fileName == SealedCredentialsFileName {
+			continue
+		}
+		filePath := filepath.Join(credentialsPath, fileName)
+		if fi, err := os.Lstat(filePath); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		err = os.Remove(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to remove %s", filePath)
+		}
+	}
+	return nil
+}
+
 // GetCA returns the contents of ca.pem
 func (creds *CredentialsBundle) GetCA() []byte {
 	return creds.Files["ca.pem"]
@@ -67,26 +171,97 @@ func (creds *CredentialsBundle) GetKey() []byte {
 	return creds.Files["key.pem"]
 }
 
-// Verify validates that we can connect to the Docker host specified in the credentials bundle
-func (creds *CredentialsBundle) Verify() error {
-	if creds.Err != nil {
-		return creds.Err
+// COE determines the container orchestration engine of the cluster from the files in the bundle
+func (creds *CredentialsBundle) COE() COE {
+	if _, ok := creds.Files["docker.env"]; ok {
+		return SwarmCOE
 	}
+	if _, ok := creds.Files["kubectl.config"]; ok {
+		return KubernetesCOE
+	}
+	if _, ok := creds.Files["mesos.env"]; ok {
+		return MesosCOE
+	}
+	return UnknownCOE
+}
 
-	tlsConfig, err := creds.GetTLSConfig()
+// DockerEnv returns the environment variables defined in docker.env, e.g. DOCKER_HOST
+func (creds *CredentialsBundle) DockerEnv() (map[string]string, error) {
+	config, ok := creds.Files["docker.env"]
+	if !ok {
+		return nil, errors.New("Invalid credentials bundle. Missing docker.env")
+	}
+
+	env, err := parseShellEnv("docker.env", config)
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "Invalid credentials bundle")
 	}
+	return env, nil
+}
 
-	host, err := creds.ParseHost()
+// KubeConfig returns the parsed contents of kubectl.config
+func (creds *CredentialsBundle) KubeConfig() (*KubeConfig, error) {
+	config, ok := creds.Files["kubectl.config"]
+	if !ok {
+		return nil, errors.New("Invalid credentials bundle. Missing kubectl.config")
+	}
+
+	kubeConfig, err := parseKubeConfig("kubectl.config", config)
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "Invalid credentials bundle")
+	}
+	return kubeConfig, nil
+}
+
+// ClusterName returns the name of the cluster, as recorded in the environment scripts by GetCredentials
+func (creds *CredentialsBundle) ClusterName() string {
+	for _, fileName := range []string{"docker.env", "kubectl.env"} {
+		script, ok := creds.Files[fileName]
+		if !ok {
+			continue
+		}
+		env, err := parseShellEnv(fileName, script)
+		if err == nil && env["CARINA_CLUSTER_NAME"] != "" {
+			return env["CARINA_CLUSTER_NAME"]
+		}
+	}
+	return ""
+}
+
+// CACertificate parses the CA certificate in ca.pem
+func (creds *CredentialsBundle) CACertificate() (*x509.Certificate, error) {
+	return parsePEMCertificate("ca.pem", creds.GetCA())
+}
+
+// ClientCertificate parses the client certificate in cert.pem
+func (creds *CredentialsBundle) ClientCertificate() (*x509.Certificate, error) {
+	return parsePEMCertificate("cert.pem", creds.GetCert())
+}
+
+func parsePEMCertificate(fileName string, data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("Invalid credentials bundle. %s does not contain a PEM encoded certificate", fileName)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid credentials bundle. Cannot parse %s", fileName)
+	}
+	return cert, nil
+}
+
+// Verify validates that we can connect to the Docker host specified in the credentials bundle
+func (creds *CredentialsBundle) Verify() error {
+	if creds.Err != nil {
+		return creds.Err
 	}
 
-	telephone := &net.Dialer{Timeout: verifyCredentialsTimeout}
-	conn, err := tls.DialWithDialer(telephone, "tcp", host, tlsConfig)
+	ctx, cancel := context.WithTimeout(context.Background(), verifyCredentialsTimeout)
+	defer cancel()
+
+	conn, err := creds.DialContext(ctx, "tcp", "")
 	if err != nil {
-		return errors.Wrapf(err, "Invalid credentials bundle. Unable to connect to %s", host)
+		return err
 	}
 	conn.Close()
 
@@ -95,26 +270,46 @@ func (creds *CredentialsBundle) Verify() error {
 
 // ParseHost finds the COE Endpoint, e.g. the swarm or kubernetes ip and port
 func (creds *CredentialsBundle) ParseHost() (string, error) {
+	hostURL, err := creds.parseHostURL()
+	if err != nil {
+		return "", err
+	}
+	return hostURL.Host, nil
+}
+
+// parseHostURL finds the URL of the COE Endpoint, always including the port
+func (creds *CredentialsBundle) parseHostURL() (*url.URL, error) {
 	var host string
-	var ok bool
 
-	if config, isDocker := creds.Files["docker.env"]; isDocker {
-		host, ok = parseHost(config, "DOCKER_HOST=")
-		if !ok {
-			return "", errors.New("Invalid credentials bundle. Could not parse DOCKER_HOST from docker.env")
+	switch creds.COE() {
+	case SwarmCOE:
+		env, err := creds.DockerEnv()
+		if err != nil {
+			return nil, err
 		}
-	} else if config, isKubernetes := creds.Files["kubectl.config"]; isKubernetes {
-		host, ok = parseHost(config, "server:")
-		if !ok {
-			return "", errors.New("Invalid credentials bundle. Could not parse server from kubectl.config")
+		host = env["DOCKER_HOST"]
+		if host == "" {
+			return nil, errors.New("Invalid credentials bundle. Could not parse DOCKER_HOST from docker.env")
+		}
+	case KubernetesCOE:
+		kubeConfig, err := creds.KubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		cluster, err := kubeConfig.CurrentCluster()
+		if err != nil {
+			return nil, errors.Wrap(ParseError{File: "kubectl.config", Message: err.Error()}, "Invalid credentials bundle")
 		}
-	} else {
-		return "", errors.New("Invalid credentials bundle. Missing both docker.env and kubectl.config")
+		host = cluster.Server
+	case MesosCOE:
+		return nil, errors.New("Invalid credentials bundle. Mesos clusters are not supported")
+	default:
+		return nil, errors.New("Invalid credentials bundle. Missing both docker.env and kubectl.config")
 	}
 
 	hostURL, err := url.Parse(host)
 	if err != nil {
-		return "", fmt.Errorf("Invalid credentials bundle. Bad host URL %s", host)
+		return nil, fmt.Errorf("Invalid credentials bundle. Bad host URL %s", host)
 	}
 
 	// The dialer gets mad if we don't specify a port
@@ -123,23 +318,11 @@ func (creds *CredentialsBundle) ParseHost() (string, error) {
 		if hostURL.Scheme == "https" {
 			hostURL.Host += ":443"
 		} else {
-			return "", fmt.Errorf("Invalid credentials bundle. Could not determine the host port from %s", host)
-		}
-	}
-
-	return hostURL.Host, nil
-}
-
-func parseHost(config []byte, token string) (string, bool) {
-	lines := strings.Split(string(config), "\n")
-	for _, line := range lines {
-		host := strings.Split(line, token)
-		if len(host) == 2 {
-			return strings.TrimSpace(host[1]), true
+			return nil, fmt.Errorf("Invalid credentials bundle. Could not determine the host port from %s", host)
 		}
 	}
 
-	return "", false
+	return hostURL, nil
 }
 
 // GetTLSConfig puts together the necessary TLS configuration to connect to the COE Endpoint returned by ParseHost
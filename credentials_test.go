@@ -0,0 +1,200 @@
+package libcarina
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const swarmDockerEnv = `# Run the command below to get environment variables set up for your cluster
+#  source docker.env
+export DOCKER_HOST=tcp://172.99.65.11:2376
+export DOCKER_TLS_VERIFY=1
+export DOCKER_CERT_PATH=$(cd $(dirname "${BASH_SOURCE[0]}") && pwd)
+`
+
+const kubernetesKubeConfig = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: ca.pem
+    server: https://172.99.79.252:6443
+  name: mycluster
+contexts:
+- context:
+    cluster: mycluster
+    user: admin
+  name: default
+current-context: default
+kind: Config
+users:
+- name: admin
+  user:
+    client-certificate: cert.pem
+    client-key: key.pem
+`
+
+func newTestBundle(files map[string]string) *CredentialsBundle {
+	creds := NewCredentialsBundle()
+	for name, contents := range files {
+		creds.Files[name] = []byte(contents)
+	}
+	return creds
+}
+
+func TestCOE(t *testing.T) {
+	testCases := []struct {
+		file string
+		coe  COE
+	}{
+		{"docker.env", SwarmCOE},
+		{"kubectl.config", KubernetesCOE},
+		{"mesos.env", MesosCOE},
+		{"README.md", UnknownCOE},
+	}
+
+	for _, tc := range testCases {
+		creds := newTestBundle(map[string]string{tc.file: ""})
+		if coe := creds.COE(); coe != tc.coe {
+			t.Errorf("expected %s for a bundle containing %s, got %s", tc.coe, tc.file, coe)
+		}
+	}
+}
+
+func TestDockerEnv(t *testing.T) {
+	creds := newTestBundle(map[string]string{"docker.env": swarmDockerEnv})
+	env, err := creds.DockerEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env["DOCKER_HOST"] != "tcp://172.99.65.11:2376" {
+		t.Error("unexpected DOCKER_HOST", env["DOCKER_HOST"])
+	}
+	if env["DOCKER_TLS_VERIFY"] != "1" {
+		t.Error("unexpected DOCKER_TLS_VERIFY", env["DOCKER_TLS_VERIFY"])
+	}
+}
+
+func TestKubeConfig(t *testing.T) {
+	creds := newTestBundle(map[string]string{"kubectl.config": kubernetesKubeConfig})
+	kubeConfig, err := creds.KubeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kubeConfig.CurrentContext != "default" {
+		t.Error("unexpected current-context", kubeConfig.CurrentContext)
+	}
+	if len(kubeConfig.Clusters) != 1 || kubeConfig.Clusters[0].Cluster.Server != "https://172.99.79.252:6443" {
+		t.Error("unexpected clusters", kubeConfig.Clusters)
+	}
+	if len(kubeConfig.Users) != 1 || kubeConfig.Users[0].User.ClientKey != "key.pem" {
+		t.Error("unexpected users", kubeConfig.Users)
+	}
+}
+
+func TestParseHost(t *testing.T) {
+	testCases := []struct {
+		file     string
+		contents string
+		host     string
+	}{
+		{"docker.env", swarmDockerEnv, "172.99.65.11:2376"},
+		{"kubectl.config", kubernetesKubeConfig, "172.99.79.252:6443"},
+	}
+
+	for _, tc := range testCases {
+		creds := newTestBundle(map[string]string{tc.file: tc.contents})
+		host, err := creds.ParseHost()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if host != tc.host {
+			t.Errorf("expected host %s from %s, got %s", tc.host, tc.file, host)
+		}
+	}
+}
+
+// newTestCertificates generates a CA, and a client certificate and key signed by it, as PEM
+func newTestCertificates(t *testing.T, notBefore time.Time, notAfter time.Time) ([]byte, []byte, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestClientCertificate(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	ca, cert, key := newTestCertificates(t, time.Now().Add(-time.Hour), notAfter)
+	creds := newTestBundle(map[string]string{"ca.pem": string(ca), "cert.pem": string(cert), "key.pem": string(key)})
+
+	clientCert, err := creds.ClientCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clientCert.NotAfter.Equal(notAfter) {
+		t.Error("unexpected expiry", clientCert.NotAfter)
+	}
+
+	caCert, err := creds.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert.Subject.CommonName != "test-ca" {
+		t.Error("unexpected CA", caCert.Subject)
+	}
+
+	creds.Files["cert.pem"] = []byte("not a certificate")
+	if _, err = creds.ClientCertificate(); err == nil {
+		t.Error("expected an error for an invalid certificate")
+	}
+}
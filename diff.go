@@ -0,0 +1,150 @@
+package libcarina
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BundleDiff describes the differences between two credentials bundles.
+// It only ever holds file names, hosts, fingerprints and serial numbers, never file contents.
+type BundleDiff struct {
+	// Added lists the files only present in the new bundle
+	Added []string
+
+	// Removed lists the files only present in the old bundle
+	Removed []string
+
+	// Changed lists the files whose contents differ
+	Changed []string
+
+	// Host is set when the COE Endpoint changed
+	Host *ValueChange
+
+	// CAFingerprint is set when the CA certificate changed
+	CAFingerprint *ValueChange
+
+	// ClientCertSerial is set when the client certificate changed
+	ClientCertSerial *ValueChange
+}
+
+// ValueChange holds the old and new value of a changed setting, an empty value means that it was missing or invalid
+type ValueChange struct {
+	Old string
+	New string
+}
+
+// DiffBundles compares two credentials bundles, such as before and after the credentials are refreshed
+func DiffBundles(a *CredentialsBundle, b *CredentialsBundle) *BundleDiff {
+	diff := &BundleDiff{}
+
+	for fileName, contents := range a.Files {
+		other, ok := b.Files[fileName]
+		switch {
+		case !ok:
+			diff.Removed = append(diff.Removed, fileName)
+		case !bytes.Equal(contents, other):
+			diff.Changed = append(diff.Changed, fileName)
+		}
+	}
+	for fileName := range b.Files {
+		if _, ok := a.Files[fileName]; !ok {
+			diff.Added = append(diff.Added, fileName)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	diff.Host = diffValue(bundleHost(a), bundleHost(b))
+	diff.CAFingerprint = diffValue(bundleCAFingerprint(a), bundleCAFingerprint(b))
+	diff.ClientCertSerial = diffValue(bundleClientCertSerial(a), bundleClientCertSerial(b))
+
+	return diff
+}
+
+// IsEmpty checks if the bundles are identical
+func (diff *BundleDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 &&
+		diff.Host == nil && diff.CAFingerprint == nil && diff.ClientCertSerial == nil
+}
+
+// String summarizes the differences, one per line
+func (diff *BundleDiff) String() string {
+	var lines []string
+	for _, fileName := range diff.Added {
+		lines = append(lines, "added "+fileName)
+	}
+	for _, fileName := range diff.Removed {
+		lines = append(lines, "removed "+fileName)
+	}
+	for _, fileName := range diff.Changed {
+		lines = append(lines, "changed "+fileName)
+	}
+	if diff.Host != nil {
+		lines = append(lines, "host "+diff.Host.String())
+	}
+	if diff.CAFingerprint != nil {
+		lines = append(lines, "CA fingerprint "+diff.CAFingerprint.String())
+	}
+	if diff.ClientCertSerial != nil {
+		lines = append(lines, "client certificate serial "+diff.ClientCertSerial.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// String formats the change as old -> new
+func (change *ValueChange) String() string {
+	from, to := change.Old, change.New
+	if from == "" {
+		from = "(none)"
+	}
+	if to == "" {
+		to = "(none)"
+	}
+	return from + " -> " + to
+}
+
+func diffValue(from string, to string) *ValueChange {
+	if from == to {
+		return nil
+	}
+	return &ValueChange{Old: from, New: to}
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a certificate, formatted as colon separated hex, e.g. AB:CD:...
+func CertificateFingerprint(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(digest))
+	for i, b := range digest {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+func bundleHost(creds *CredentialsBundle) string {
+	host, err := creds.ParseHost()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func bundleCAFingerprint(creds *CredentialsBundle) string {
+	cert, err := creds.CACertificate()
+	if err != nil {
+		return ""
+	}
+	return CertificateFingerprint(cert)
+}
+
+func bundleClientCertSerial(creds *CredentialsBundle) string {
+	cert, err := creds.ClientCertificate()
+	if err != nil {
+		return ""
+	}
+	return cert.SerialNumber.String()
+}
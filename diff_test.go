@@ -0,0 +1,67 @@
+package libcarina
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCertifiedBundle(t *testing.T, dockerHost string) *CredentialsBundle {
+	ca, cert, key := newTestCertificates(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	return newTestBundle(map[string]string{
+		"docker.env": "export DOCKER_HOST=" + dockerHost + "\n",
+		"ca.pem":     string(ca),
+		"cert.pem":   string(cert),
+		"key.pem":    string(key),
+	})
+}
+
+func TestDiffBundles(t *testing.T) {
+	a := newTestCertifiedBundle(t, "tcp://172.99.65.11:2376")
+
+	if diff := DiffBundles(a, a); !diff.IsEmpty() {
+		t.Error("expected no differences, got", diff)
+	}
+
+	b := newTestCertifiedBundle(t, "tcp://172.99.65.12:2376")
+	b.Files["ca.pem"] = a.Files["ca.pem"]
+	b.Files["README.md"] = []byte("hello")
+	b.Files["docker.ps1"] = []byte("$env:DOCKER_HOST = 'tcp://172.99.65.12:2376'")
+
+	diff := DiffBundles(a, b)
+	if strings.Join(diff.Added, ",") != "README.md,docker.ps1" {
+		t.Error("unexpected added files", diff.Added)
+	}
+	if strings.Join(diff.Changed, ",") != "cert.pem,docker.env,key.pem" {
+		t.Error("unexpected changed files", diff.Changed)
+	}
+	if diff.Host == nil || diff.Host.Old != "172.99.65.11:2376" || diff.Host.New != "172.99.65.12:2376" {
+		t.Error("unexpected host change", diff.Host)
+	}
+	if diff.CAFingerprint != nil {
+		t.Error("expected the CA to be unchanged, got", diff.CAFingerprint)
+	}
+	if diff.ClientCertSerial == nil {
+		t.Error("expected the client certificate serial to change")
+	}
+
+	diff = DiffBundles(b, a)
+	if strings.Join(diff.Removed, ",") != "README.md,docker.ps1" {
+		t.Error("unexpected removed files", diff.Removed)
+	}
+}
+
+func TestDiffBundlesNeverIncludesKeyMaterial(t *testing.T) {
+	a := newTestCertifiedBundle(t, "tcp://172.99.65.11:2376")
+	b := newTestCertifiedBundle(t, "tcp://172.99.65.11:2376")
+
+	summary := DiffBundles(a, b).String()
+	for _, fileName := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		if strings.Contains(summary, "BEGIN") || strings.Contains(summary, string(b.Files[fileName][30:60])) {
+			t.Fatalf("expected no file contents in the summary, got %s", summary)
+		}
+	}
+	if !strings.Contains(summary, "CA fingerprint") || !strings.Contains(summary, "changed key.pem") {
+		t.Error("unexpected summary", summary)
+	}
+}
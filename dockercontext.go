@@ -0,0 +1,177 @@
+package libcarina
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// dockerContextName matches the names accepted by the Docker CLI for contexts
+var dockerContextName = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.+-]+$")
+
+// DockerContextOpts defines the set of parameters when creating a Docker CLI context
+type DockerContextOpts struct {
+	// ConfigDir is the Docker CLI configuration directory, defaults to $DOCKER_CONFIG or ~/.docker
+	ConfigDir string
+
+	// Name of the context, defaults to the Carina cluster name
+	Name string
+
+	// Description of the context, shown by docker context ls
+	Description string
+}
+
+// dockerContextMetadata is the meta.json file read by the Docker CLI context store
+type dockerContextMetadata struct {
+	Name      string                           `json:"Name"`
+	Metadata  dockerContextDescription         `json:"Metadata"`
+	Endpoints map[string]dockerContextEndpoint `json:"Endpoints"`
+}
+
+type dockerContextDescription struct {
+	Description string `json:"Description,omitempty"`
+}
+
+type dockerContextEndpoint struct {
+	Host          string `json:"Host"`
+	SkipTLSVerify bool   `json:"SkipTLSVerify"`
+}
+
+// DefaultDockerConfigDir returns the default Docker CLI configuration directory, $DOCKER_CONFIG or ~/.docker
+func DefaultDockerConfigDir() (string, error) {
+	if configDir := os.Getenv("DOCKER_CONFIG"); configDir != "" {
+		return configDir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to determine the default Docker configuration directory")
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// WriteDockerContext saves a Swarm credentials bundle as a Docker CLI context, so that it can be used with docker --context.
+// An existing context with the same name is replaced. Returns the name of the context.
+func WriteDockerContext(creds *CredentialsBundle, opts *DockerContextOpts) (string, error) {
+	if opts == nil {
+		opts = &DockerContextOpts{}
+	}
+
+	if coe := creds.COE(); coe != SwarmCOE {
+		return "", fmt.Errorf("Unable to create a Docker context for a %s cluster", coe)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = creds.ClusterName()
+	}
+	if !dockerContextName.MatchString(name) {
+		return "", fmt.Errorf("Unable to create a Docker context. Invalid context name %q, specify a name matching %s", name, dockerContextName)
+	}
+
+	env, err := creds.DockerEnv()
+	if err != nil {
+		return "", err
+	}
+	host := env["DOCKER_HOST"]
+	if host == "" {
+		return "", errors.New("Invalid credentials bundle. Could not parse DOCKER_HOST from docker.env")
+	}
+	tlsVerify := env["DOCKER_TLS_VERIFY"] != "" && env["DOCKER_TLS_VERIFY"] != "0"
+
+	metaDir, tlsDir, err := dockerContextDirs(opts.ConfigDir, name)
+	if err != nil {
+		return "", err
+	}
+
+	meta := dockerContextMetadata{
+		Name:     name,
+		Metadata: dockerContextDescription{Description: opts.Description},
+		Endpoints: map[string]dockerContextEndpoint{
+			"docker": {Host: host, SkipTLSVerify: !tlsVerify},
+		},
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	// Start from a clean slate so that stale TLS material from a previous context is not left behind
+	err = os.RemoveAll(tlsDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to remove %s", tlsDir)
+	}
+
+	dockerTLSDir := filepath.Join(tlsDir, "docker")
+	err = os.MkdirAll(dockerTLSDir, 0700)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to create %s", dockerTLSDir)
+	}
+	for _, fileName := range []string{"ca.pem", "cert.pem", "key.pem"} {
+		contents, ok := creds.Files[fileName]
+		if !ok {
+			return "", fmt.Errorf("Invalid credentials bundle. Missing %s", fileName)
+		}
+		filePath := filepath.Join(dockerTLSDir, fileName)
+		err = ioutil.WriteFile(filePath, contents, 0600)
+		if err != nil {
+			return "", errors.Wrapf(err, "Unable to write %s", filePath)
+		}
+	}
+
+	err = os.MkdirAll(metaDir, 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to create %s", metaDir)
+	}
+	metaPath := filepath.Join(metaDir, "meta.json")
+	err = ioutil.WriteFile(metaPath, metaJSON, 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to write %s", metaPath)
+	}
+
+	return name, nil
+}
+
+// RemoveDockerContext deletes a Docker CLI context. Only opts.ConfigDir is used, and it is not an error if the context does not exist.
+func RemoveDockerContext(name string, opts *DockerContextOpts) error {
+	if opts == nil {
+		opts = &DockerContextOpts{}
+	}
+
+	metaDir, tlsDir, err := dockerContextDirs(opts.ConfigDir, name)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{metaDir, tlsDir} {
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to remove %s", dir)
+		}
+	}
+	return nil
+}
+
+// dockerContextDirs returns the directories used by the Docker CLI context store for a context.
+// The store identifies contexts by the SHA-256 digest of their name.
+func dockerContextDirs(configDir string, name string) (string, string, error) {
+	if configDir == "" {
+		var err error
+		configDir, err = DefaultDockerConfigDir()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	digest := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(digest[:])
+	contextsDir := filepath.Join(configDir, "contexts")
+	return filepath.Join(contextsDir, "meta", id), filepath.Join(contextsDir, "tls", id), nil
+}
@@ -0,0 +1,72 @@
+package libcarina
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSwarmBundle(name string) *CredentialsBundle {
+	return newTestBundle(map[string]string{
+		"docker.env": swarmDockerEnv + "export CARINA_CLUSTER_NAME=" + name + "\n",
+		"ca.pem":     "fake-ca",
+		"cert.pem":   "fake-cert",
+		"key.pem":    "fake-key",
+	})
+}
+
+func TestWriteDockerContext(t *testing.T) {
+	configDir := t.TempDir()
+	creds := newTestSwarmBundle("mycluster")
+
+	name, err := WriteDockerContext(creds, &DockerContextOpts{ConfigDir: configDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "mycluster" {
+		t.Error("expected the context to be named after the cluster, got", name)
+	}
+
+	digest := sha256.Sum256([]byte("mycluster"))
+	id := hex.EncodeToString(digest[:])
+
+	metaJSON, err := ioutil.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta dockerContextMetadata
+	if err = json.Unmarshal(metaJSON, &meta); err != nil {
+		t.Fatal(err)
+	}
+	endpoint := meta.Endpoints["docker"]
+	if meta.Name != "mycluster" || endpoint.Host != "tcp://172.99.65.11:2376" || endpoint.SkipTLSVerify {
+		t.Error("unexpected meta.json", string(metaJSON))
+	}
+
+	key, err := ioutil.ReadFile(filepath.Join(configDir, "contexts", "tls", id, "docker", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "fake-key" {
+		t.Error("unexpected key.pem", string(key))
+	}
+
+	err = RemoveDockerContext("mycluster", &DockerContextOpts{ConfigDir: configDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(configDir, "contexts", "meta", id)); !os.IsNotExist(err) {
+		t.Error("expected the context to be removed")
+	}
+}
+
+func TestWriteDockerContextRequiresSwarm(t *testing.T) {
+	_, err := WriteDockerContext(newTestKubernetesBundle("mycluster"), &DockerContextOpts{ConfigDir: t.TempDir()})
+	if err == nil {
+		t.Error("expected an error for a Kubernetes bundle")
+	}
+}
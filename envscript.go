@@ -0,0 +1,297 @@
+package libcarina
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Shell identifies the syntax used when rendering environment scripts
+type Shell string
+
+const (
+	// BashShell renders export statements for bash, zsh and other POSIX shells
+	BashShell Shell = "bash"
+
+	// FishShell renders set -gx statements for fish
+	FishShell Shell = "fish"
+
+	// PowerShell renders $env: assignments for PowerShell
+	PowerShell Shell = "powershell"
+
+	// CmdShell renders set statements for Windows cmd.exe batch files
+	CmdShell Shell = "cmd"
+
+	// NuShell renders $env assignments for nushell
+	NuShell Shell = "nushell"
+
+	// ElvishShell renders set-env statements for elvish
+	ElvishShell Shell = "elvish"
+
+	// JSONFormat renders a JSON object of variable names to values
+	JSONFormat Shell = "json"
+
+	// DotenvFormat renders NAME=value lines, as read by docker compose and most dotenv libraries
+	DotenvFormat Shell = "dotenv"
+)
+
+// EnvVar is an environment variable set by an environment script
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// ParseShell converts the name of a shell, or a common alias such as zsh or ps1, to a Shell
+func ParseShell(name string) (Shell, error) {
+	switch strings.ToLower(name) {
+	case "bash", "sh", "zsh", "ksh", "posix":
+		return BashShell, nil
+	case "fish":
+		return FishShell, nil
+	case "powershell", "pwsh", "ps1":
+		return PowerShell, nil
+	case "cmd", "bat":
+		return CmdShell, nil
+	case "nushell", "nu":
+		return NuShell, nil
+	case "elvish", "elv":
+		return ElvishShell, nil
+	case "json":
+		return JSONFormat, nil
+	case "dotenv", "env":
+		return DotenvFormat, nil
+	}
+	return "", fmt.Errorf("Unsupported shell %s", name)
+}
+
+// EnvironmentVariables returns the variables needed to connect to the cluster,
+// with certificate paths pointing at the bundle once saved to saveDir.
+func (creds *CredentialsBundle) EnvironmentVariables(saveDir string) ([]EnvVar, error) {
+	saveDir, err := filepath.Abs(saveDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var vars []EnvVar
+	var scriptName string
+	switch coe := creds.COE(); coe {
+	case SwarmCOE:
+		scriptName = "docker.env"
+		env, err := creds.DockerEnv()
+		if err != nil {
+			return nil, err
+		}
+		if env["DOCKER_HOST"] == "" {
+			return nil, errors.New("Invalid credentials bundle. Could not parse DOCKER_HOST from docker.env")
+		}
+		tlsVerify := env["DOCKER_TLS_VERIFY"]
+		if tlsVerify == "" {
+			tlsVerify = "1"
+		}
+		vars = append(vars,
+			EnvVar{"DOCKER_HOST", env["DOCKER_HOST"]},
+			EnvVar{"DOCKER_TLS_VERIFY", tlsVerify},
+			EnvVar{"DOCKER_CERT_PATH", saveDir})
+		if version := env["DOCKER_VERSION"]; version != "" && !strings.Contains(version, "$") {
+			vars = append(vars, EnvVar{"DOCKER_VERSION", version})
+		}
+	case KubernetesCOE:
+		scriptName = "kubectl.env"
+		vars = append(vars, EnvVar{"KUBECONFIG", filepath.Join(saveDir, "kubectl.config")})
+	default:
+		return nil, fmt.Errorf("Unable to determine the environment variables for a %s cluster", coe)
+	}
+
+	// Carry through the cluster metadata recorded in the scripts, e.g. CARINA_CLUSTER_NAME
+	if script, ok := creds.Files[scriptName]; ok {
+		env, err := parseShellEnv(scriptName, script)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid credentials bundle")
+		}
+		for _, name := range sortedKeys(env) {
+			if strings.HasPrefix(name, "CARINA_") {
+				vars = append(vars, EnvVar{name, env[name]})
+			}
+		}
+	}
+
+	return vars, nil
+}
+
+// RenderEnv builds an environment script for the specified shell, see EnvironmentVariables
+func (creds *CredentialsBundle) RenderEnv(shell Shell, saveDir string) ([]byte, error) {
+	vars, err := creds.EnvironmentVariables(saveDir)
+	if err != nil {
+		return nil, err
+	}
+	return RenderEnv(shell, vars)
+}
+
+// RenderEnv builds a script which sets the environment variables in the specified shell, quoting values as necessary
+func RenderEnv(shell Shell, vars []EnvVar) ([]byte, error) {
+	if shell == JSONFormat {
+		return renderJSONEnv(vars)
+	}
+
+	var script bytes.Buffer
+	for _, v := range vars {
+		stmt, err := renderEnvVar(shell, v)
+		if err != nil {
+			return nil, err
+		}
+		script.WriteString(stmt)
+		script.WriteString("\n")
+	}
+	return script.Bytes(), nil
+}
+
+// renderEnvVar builds a single statement which sets an environment variable in the specified shell
+func renderEnvVar(shell Shell, v EnvVar) (string, error) {
+	if !shellVariableName.MatchString(v.Name) {
+		return "", fmt.Errorf("Invalid environment variable name %q", v.Name)
+	}
+
+	switch shell {
+	case BashShell:
+		return fmt.Sprintf("export %s=%s", v.Name, quotePosix(v.Value)), nil
+	case FishShell:
+		return fmt.Sprintf("set -gx %s %s", v.Name, quoteFish(v.Value)), nil
+	case PowerShell:
+		return fmt.Sprintf("$env:%s = %s", v.Name, quotePowerShell(v.Value)), nil
+	case CmdShell:
+		value, err := quoteCmd(v.Value)
+		if err != nil {
+			return "", errors.Wrapf(err, "Unable to set %s", v.Name)
+		}
+		return fmt.Sprintf("set %s=%s", v.Name, value), nil
+	case NuShell:
+		return fmt.Sprintf("$env.%s = %s", v.Name, quoteNushell(v.Value)), nil
+	case ElvishShell:
+		return fmt.Sprintf("set-env %s %s", v.Name, quoteElvish(v.Value)), nil
+	case DotenvFormat:
+		return fmt.Sprintf("%s=%s", v.Name, quoteDotenv(v.Value)), nil
+	}
+	return "", fmt.Errorf("Unsupported shell %s", shell)
+}
+
+func renderJSONEnv(vars []EnvVar) ([]byte, error) {
+	// Build the object by hand to preserve the order of the variables
+	var script bytes.Buffer
+	script.WriteString("{")
+	for i, v := range vars {
+		if i > 0 {
+			script.WriteString(",")
+		}
+		name, _ := json.Marshal(v.Name)
+		value, _ := json.Marshal(v.Value)
+		script.WriteString("\n  ")
+		script.Write(name)
+		script.WriteString(": ")
+		script.Write(value)
+	}
+	script.WriteString("\n}\n")
+	return script.Bytes(), nil
+}
+
+// quotePosix single-quotes a value, the only character which needs special handling is the single quote itself
+func quotePosix(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// quoteFish single-quotes a value, in which fish only interprets \\ and \'
+func quoteFish(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "'", `\'`, -1)
+	return "'" + value + "'"
+}
+
+// quotePowerShell single-quotes a value, PowerShell treats any of its quote characters as a quote so each is doubled
+func quotePowerShell(value string) string {
+	var quoted bytes.Buffer
+	quoted.WriteString("'")
+	for _, r := range value {
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			quoted.WriteRune(r)
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteString("'")
+	return quoted.String()
+}
+
+// quoteCmd escapes a value for a set statement in a batch file.
+// Metacharacters are escaped with ^ and % is doubled, line breaks cannot be represented at all.
+func quoteCmd(value string) (string, error) {
+	if strings.ContainsAny(value, "\r\n") {
+		return "", errors.New("cmd does not support line breaks in environment variables")
+	}
+
+	var quoted bytes.Buffer
+	for _, r := range value {
+		switch r {
+		case '^', '&', '|', '<', '>', '"', '(', ')':
+			quoted.WriteRune('^')
+		case '%':
+			quoted.WriteRune('%')
+		}
+		quoted.WriteRune(r)
+	}
+	return quoted.String(), nil
+}
+
+// quoteNushell double-quotes a value, which nushell does not interpolate
+func quoteNushell(value string) string {
+	return quoteBackslashed(value)
+}
+
+// quoteElvish single-quotes a value, the single quote itself is doubled
+func quoteElvish(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// quoteDotenv single-quotes a value when possible, as dotenv parsers treat the contents literally,
+// falling back to a double-quoted value with backslash escapes.
+func quoteDotenv(value string) string {
+	if !strings.ContainsAny(value, "'\r\n") {
+		return "'" + value + "'"
+	}
+	return quoteBackslashed(value)
+}
+
+// quoteBackslashed double-quotes a value using backslash escapes
+func quoteBackslashed(value string) string {
+	var quoted bytes.Buffer
+	quoted.WriteString(`"`)
+	for _, r := range value {
+		switch r {
+		case '\\', '"':
+			quoted.WriteRune('\\')
+			quoted.WriteRune(r)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteString(`"`)
+	return quoted.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package libcarina
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const trickyValue = `my "cluster's" $HOME`
+
+func TestRenderEnv(t *testing.T) {
+	vars := []EnvVar{{"CARINA_CLUSTER_NAME", trickyValue}}
+
+	testCases := []struct {
+		shell  Shell
+		script string
+	}{
+		{BashShell, `export CARINA_CLUSTER_NAME='my "cluster'\''s" $HOME'`},
+		{FishShell, `set -gx CARINA_CLUSTER_NAME 'my "cluster\'s" $HOME'`},
+		{PowerShell, `$env:CARINA_CLUSTER_NAME = 'my "cluster''s" $HOME'`},
+		{CmdShell, `set CARINA_CLUSTER_NAME=my ^"cluster's^" $HOME`},
+		{NuShell, `$env.CARINA_CLUSTER_NAME = "my \"cluster's\" $HOME"`},
+		{ElvishShell, `set-env CARINA_CLUSTER_NAME 'my "cluster''s" $HOME'`},
+		{DotenvFormat, `CARINA_CLUSTER_NAME="my \"cluster's\" $HOME"`},
+	}
+
+	for _, tc := range testCases {
+		script, err := RenderEnv(tc.shell, vars)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if strings.TrimSpace(string(script)) != tc.script {
+			t.Errorf("unexpected %s script\nexpected: %s\nactual:   %s", tc.shell, tc.script, script)
+		}
+	}
+}
+
+func TestRenderEnvRoundTrip(t *testing.T) {
+	vars := []EnvVar{{"A", trickyValue}, {"B", "`rm -rf /`; $(reboot)"}, {"C", ""}}
+	script, err := RenderEnv(BashShell, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := parseShellEnv("test.env", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vars {
+		if env[v.Name] != v.Value {
+			t.Errorf("expected %s=%s, got %s", v.Name, v.Value, env[v.Name])
+		}
+	}
+
+	script, err = RenderEnv(JSONFormat, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonEnv map[string]string
+	if err = json.Unmarshal(script, &jsonEnv); err != nil {
+		t.Fatal(err)
+	}
+	if jsonEnv["A"] != trickyValue {
+		t.Error("unexpected JSON value", jsonEnv["A"])
+	}
+}
+
+func TestRenderEnvErrors(t *testing.T) {
+	if _, err := RenderEnv(CmdShell, []EnvVar{{"A", "line\nbreak"}}); err == nil {
+		t.Error("expected an error for a line break in a cmd script")
+	}
+	if _, err := RenderEnv(BashShell, []EnvVar{{"A;rm", "value"}}); err == nil {
+		t.Error("expected an error for an invalid variable name")
+	}
+}
+
+func TestEnvironmentVariables(t *testing.T) {
+	saveDir := t.TempDir()
+
+	script, err := newTestSwarmBundle("mycluster").RenderEnv(BashShell, saveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := parseShellEnv("docker.env", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["DOCKER_HOST"] != "tcp://172.99.65.11:2376" || env["DOCKER_CERT_PATH"] != saveDir {
+		t.Error("unexpected docker variables", env)
+	}
+
+	vars, err := newTestKubernetesBundle("mycluster").EnvironmentVariables(saveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []EnvVar{
+		{"KUBECONFIG", filepath.Join(saveDir, "kubectl.config")},
+		{"CARINA_CLUSTER_NAME", "mycluster"},
+	}
+	if len(vars) != len(expected) || vars[0] != expected[0] || vars[1] != expected[1] {
+		t.Error("unexpected kubernetes variables", vars)
+	}
+}
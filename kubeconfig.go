@@ -0,0 +1,471 @@
+package libcarina
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// KubeConfig is the parsed contents of a kubectl configuration file, e.g. kubectl.config
+type KubeConfig struct {
+	APIVersion     string              `yaml:"apiVersion,omitempty"`
+	Kind           string              `yaml:"kind,omitempty"`
+	Clusters       []*KubeNamedCluster `yaml:"clusters"`
+	Users          []*KubeNamedUser    `yaml:"users"`
+	Contexts       []*KubeNamedContext `yaml:"contexts"`
+	CurrentContext string              `yaml:"current-context"`
+
+	// Extra holds any additional fields, such as preferences, so that they are preserved when the file is rewritten
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// KubeNamedCluster associates a name with a cluster entry in a KubeConfig
+type KubeNamedCluster struct {
+	Name    string       `yaml:"name"`
+	Cluster *KubeCluster `yaml:"cluster"`
+}
+
+// KubeCluster defines how to connect to a Kubernetes API server
+type KubeCluster struct {
+	// Server is the URL of the Kubernetes API server
+	Server string `yaml:"server"`
+
+	// CertificateAuthority is the path to the CA certificate
+	CertificateAuthority string `yaml:"certificate-authority,omitempty"`
+
+	// CertificateAuthorityData is the base64 encoded CA certificate
+	CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+
+	// InsecureSkipTLSVerify disables verification of the server certificate
+	InsecureSkipTLSVerify bool `yaml:"insecure-skip-tls-verify,omitempty"`
+
+	// Extra holds any additional fields, so that they are preserved when the file is rewritten
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// KubeNamedUser associates a name with a user entry in a KubeConfig
+type KubeNamedUser struct {
+	Name string    `yaml:"name"`
+	User *KubeUser `yaml:"user"`
+}
+
+// KubeUser defines how to authenticate to a Kubernetes API server
+type KubeUser struct {
+	// ClientCertificate is the path to the client certificate
+	ClientCertificate string `yaml:"client-certificate,omitempty"`
+
+	// ClientCertificateData is the base64 encoded client certificate
+	ClientCertificateData string `yaml:"client-certificate-data,omitempty"`
+
+	// ClientKey is the path to the client key
+	ClientKey string `yaml:"client-key,omitempty"`
+
+	// ClientKeyData is the base64 encoded client key
+	ClientKeyData string `yaml:"client-key-data,omitempty"`
+
+	// Token is a bearer token
+	Token string `yaml:"token,omitempty"`
+
+	// Username is used for basic authentication
+	Username string `yaml:"username,omitempty"`
+
+	// Password is used for basic authentication
+	Password string `yaml:"password,omitempty"`
+
+	// Extra holds any additional fields, so that they are preserved when the file is rewritten
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// KubeNamedContext associates a name with a context entry in a KubeConfig
+type KubeNamedContext struct {
+	Name    string       `yaml:"name"`
+	Context *KubeContext `yaml:"context"`
+}
+
+// KubeContext pairs a cluster with the user used to connect to it
+type KubeContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// Extra holds any additional fields, so that they are preserved when the file is rewritten
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// ParseKubeConfig reads a kubectl configuration file
+func ParseKubeConfig(config []byte) (*KubeConfig, error) {
+	return parseKubeConfig("", config)
+}
+
+func parseKubeConfig(file string, config []byte) (*KubeConfig, error) {
+	kubeConfig := &KubeConfig{}
+	err := yaml.Unmarshal(config, kubeConfig)
+	if err != nil {
+		return nil, errors.WithStack(yamlParseError(file, err))
+	}
+	return kubeConfig, nil
+}
+
+// yamlParseError converts an error from the yaml package into a ParseError with the offending line
+func yamlParseError(file string, err error) error {
+	match := yamlErrorLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return ParseError{File: file, Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(match[1])
+	return ParseError{File: file, Line: line, Message: match[2]}
+}
+
+// FindCluster returns the cluster with the specified name, or nil if it is not defined
+func (config *KubeConfig) FindCluster(name string) *KubeCluster {
+	for _, cluster := range config.Clusters {
+		if cluster.Name == name {
+			return cluster.Cluster
+		}
+	}
+	return nil
+}
+
+// FindUser returns the user with the specified name, or nil if it is not defined
+func (config *KubeConfig) FindUser(name string) *KubeUser {
+	for _, user := range config.Users {
+		if user.Name == name {
+			return user.User
+		}
+	}
+	return nil
+}
+
+// FindContext returns the context with the specified name, or nil if it is not defined
+func (config *KubeConfig) FindContext(name string) *KubeContext {
+	for _, context := range config.Contexts {
+		if context.Name == name {
+			return context.Context
+		}
+	}
+	return nil
+}
+
+// CurrentCluster returns the cluster referenced by the current context.
+// When current-context is not set, a lone context or a lone cluster is used instead.
+func (config *KubeConfig) CurrentCluster() (*KubeCluster, error) {
+	contextName := config.CurrentContext
+	if contextName == "" {
+		switch {
+		case len(config.Contexts) == 1:
+			contextName = config.Contexts[0].Name
+		case len(config.Contexts) == 0 && len(config.Clusters) == 1:
+			return validateKubeCluster(config.Clusters[0].Name, config.Clusters[0].Cluster)
+		default:
+			return nil, errors.New("current-context is not set")
+		}
+	}
+
+	context := config.FindContext(contextName)
+	if context == nil {
+		return nil, fmt.Errorf("current-context %q is not defined", contextName)
+	}
+
+	cluster := config.FindCluster(context.Cluster)
+	if cluster == nil {
+		return nil, fmt.Errorf("context %q references undefined cluster %q", contextName, context.Cluster)
+	}
+
+	return validateKubeCluster(context.Cluster, cluster)
+}
+
+func validateKubeCluster(name string, cluster *KubeCluster) (*KubeCluster, error) {
+	if cluster == nil || cluster.Server == "" {
+		return nil, fmt.Errorf("cluster %q does not specify a server", name)
+	}
+	return cluster, nil
+}
+
+// KubeConfigConflictPolicy determines what happens when a kubeconfig already has an entry with the requested name
+type KubeConfigConflictPolicy int
+
+const (
+	// KubeConfigConflictError fails the merge, leaving the kubeconfig untouched
+	KubeConfigConflictError KubeConfigConflictPolicy = iota
+
+	// KubeConfigConflictOverwrite replaces the existing entries
+	KubeConfigConflictOverwrite
+
+	// KubeConfigConflictRename picks a new name by appending a numeric suffix, e.g. mycluster-2
+	KubeConfigConflictRename
+)
+
+// KubeConfigMergeOpts defines the set of parameters when merging credentials into a kubeconfig
+type KubeConfigMergeOpts struct {
+	// Path to the kubeconfig file, defaults to ~/.kube/config
+	Path string
+
+	// Name of the cluster, user and context entries, defaults to the Carina cluster name
+	Name string
+
+	// SwitchContext sets current-context to the merged context
+	SwitchContext bool
+
+	// Conflict determines how to handle existing entries with the same name
+	Conflict KubeConfigConflictPolicy
+}
+
+// DefaultKubeConfigPath returns the default location of the kubeconfig file, ~/.kube/config
+func DefaultKubeConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to determine the default kubeconfig path")
+	}
+	return filepath.Join(home, ".kube", "config"), nil
+}
+
+// MergeKubeConfig adds the cluster, user and context from a Kubernetes credentials bundle to a kubeconfig file.
+// Certificates are embedded inline so that the kubeconfig does not depend upon the bundle remaining on disk.
+// Returns the name used for the merged entries.
+func MergeKubeConfig(creds *CredentialsBundle, opts *KubeConfigMergeOpts) (string, error) {
+	if opts == nil {
+		opts = &KubeConfigMergeOpts{}
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = creds.ClusterName()
+	}
+	if name == "" {
+		return "", errors.New("Unable to merge credentials into the kubeconfig. The cluster name could not be determined, specify a name explicitly")
+	}
+
+	cluster, user, context, err := creds.inlineKubeConfigEntries()
+	if err != nil {
+		return "", err
+	}
+
+	configPath, kubeConfig, err := readKubeConfigFile(opts.Path)
+	if err != nil {
+		return "", err
+	}
+
+	if kubeConfig.hasEntry(name) {
+		switch opts.Conflict {
+		case KubeConfigConflictOverwrite:
+			kubeConfig.Remove(name)
+		case KubeConfigConflictRename:
+			base := name
+			for i := 2; kubeConfig.hasEntry(name); i++ {
+				name = fmt.Sprintf("%s-%d", base, i)
+			}
+		default:
+			return "", fmt.Errorf("Unable to merge credentials into %s. An entry named %s already exists", configPath, name)
+		}
+	}
+
+	context.Cluster = name
+	context.User = name
+	kubeConfig.Clusters = append(kubeConfig.Clusters, &KubeNamedCluster{Name: name, Cluster: cluster})
+	kubeConfig.Users = append(kubeConfig.Users, &KubeNamedUser{Name: name, User: user})
+	kubeConfig.Contexts = append(kubeConfig.Contexts, &KubeNamedContext{Name: name, Context: context})
+	if opts.SwitchContext || kubeConfig.CurrentContext == "" {
+		kubeConfig.CurrentContext = name
+	}
+
+	return name, writeKubeConfigFile(configPath, kubeConfig)
+}
+
+// RemoveKubeConfig removes the cluster, user and context entries with the specified name from the kubeconfig file at configPath,
+// which defaults to ~/.kube/config when empty. It is not an error if the entries do not exist.
+func RemoveKubeConfig(name string, configPath string) error {
+	configPath, kubeConfig, err := readKubeConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	if !kubeConfig.hasEntry(name) {
+		return nil
+	}
+
+	kubeConfig.Remove(name)
+	return writeKubeConfigFile(configPath, kubeConfig)
+}
+
+// Remove deletes the cluster, user and context with the specified name.
+// The current context is cleared if it referred to the removed context.
+func (config *KubeConfig) Remove(name string) {
+	var clusters []*KubeNamedCluster
+	for _, cluster := range config.Clusters {
+		if cluster.Name != name {
+			clusters = append(clusters, cluster)
+		}
+	}
+	config.Clusters = clusters
+
+	var users []*KubeNamedUser
+	for _, user := range config.Users {
+		if user.Name != name {
+			users = append(users, user)
+		}
+	}
+	config.Users = users
+
+	var contexts []*KubeNamedContext
+	for _, context := range config.Contexts {
+		if context.Name != name {
+			contexts = append(contexts, context)
+		}
+	}
+	config.Contexts = contexts
+
+	if config.CurrentContext == name {
+		config.CurrentContext = ""
+	}
+}
+
+func (config *KubeConfig) hasEntry(name string) bool {
+	return config.FindCluster(name) != nil || config.FindUser(name) != nil || config.FindContext(name) != nil
+}
+
+// inlineKubeConfigEntries builds kubeconfig entries for the current context of the bundle, with the certificates embedded
+func (creds *CredentialsBundle) inlineKubeConfigEntries() (*KubeCluster, *KubeUser, *KubeContext, error) {
+	if coe := creds.COE(); coe != KubernetesCOE {
+		return nil, nil, nil, fmt.Errorf("Unable to create a kubeconfig for a %s cluster", coe)
+	}
+
+	bundleConfig, err := creds.KubeConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bundleCluster, err := bundleConfig.CurrentCluster()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(ParseError{File: "kubectl.config", Message: err.Error()}, "Invalid credentials bundle")
+	}
+
+	context := &KubeContext{}
+	bundleUser := &KubeUser{}
+	if c := bundleConfig.FindContext(bundleConfig.CurrentContext); c != nil {
+		context.Namespace = c.Namespace
+		if u := bundleConfig.FindUser(c.User); u != nil {
+			bundleUser = u
+		}
+	}
+
+	cluster := &KubeCluster{
+		Server:                   bundleCluster.Server,
+		CertificateAuthorityData: bundleCluster.CertificateAuthorityData,
+		InsecureSkipTLSVerify:    bundleCluster.InsecureSkipTLSVerify,
+	}
+	if cluster.CertificateAuthorityData == "" {
+		cluster.CertificateAuthorityData = creds.inlineFile(bundleCluster.CertificateAuthority, "ca.pem")
+	}
+
+	user := &KubeUser{
+		ClientCertificateData: bundleUser.ClientCertificateData,
+		ClientKeyData:         bundleUser.ClientKeyData,
+		Token:                 bundleUser.Token,
+		Username:              bundleUser.Username,
+		Password:              bundleUser.Password,
+	}
+	if user.ClientCertificateData == "" {
+		user.ClientCertificateData = creds.inlineFile(bundleUser.ClientCertificate, "cert.pem")
+	}
+	if user.ClientKeyData == "" {
+		user.ClientKeyData = creds.inlineFile(bundleUser.ClientKey, "key.pem")
+	}
+
+	return cluster, user, context, nil
+}
+
+// inlineFile base64 encodes a file referenced by kubectl.config, falling back to a well-known file in the bundle
+func (creds *CredentialsBundle) inlineFile(reference string, fallback string) string {
+	contents, ok := creds.Files[filepath.Base(reference)]
+	if reference == "" || !ok {
+		contents = creds.Files[fallback]
+	}
+	if len(contents) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(contents)
+}
+
+func readKubeConfigFile(configPath string) (string, *KubeConfig, error) {
+	if configPath == "" {
+		var err error
+		configPath, err = DefaultKubeConfigPath()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	contents, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return configPath, &KubeConfig{APIVersion: "v1", Kind: "Config"}, nil
+	}
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Unable to read %s", configPath)
+	}
+
+	kubeConfig, err := parseKubeConfig(configPath, contents)
+	if err != nil {
+		return "", nil, err
+	}
+	if kubeConfig.APIVersion == "" {
+		kubeConfig.APIVersion = "v1"
+	}
+	if kubeConfig.Kind == "" {
+		kubeConfig.Kind = "Config"
+	}
+	return configPath, kubeConfig, nil
+}
+
+// writeKubeConfigFile replaces the kubeconfig file, writing to a temporary file first so that it is never left half-written.
+// A symlinked kubeconfig is written through to its target, and the permissions of an existing file are kept.
+func writeKubeConfigFile(configPath string, kubeConfig *KubeConfig) error {
+	contents, err := yaml.Marshal(kubeConfig)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if target, err := filepath.EvalSymlinks(configPath); err == nil {
+		configPath = target
+	}
+
+	var mode os.FileMode = 0600
+	if info, err := os.Stat(configPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	err = os.MkdirAll(filepath.Dir(configPath), 0700)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", filepath.Dir(configPath))
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(configPath), filepath.Base(configPath)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+
+	err = os.Rename(tmp.Name(), configPath)
+	return errors.Wrapf(err, "Unable to write %s", configPath)
+}
@@ -0,0 +1,260 @@
+package libcarina
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const multiClusterKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: other
+  cluster:
+    server: https://10.0.0.1:6443
+- name: mycluster
+  cluster:
+    certificate-authority: ca.pem
+    server: https://172.99.79.252:6443
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: admin
+- name: mycluster
+  context:
+    cluster: mycluster
+    user: admin
+    namespace: "server: not-a-server"
+current-context: mycluster
+users:
+- name: admin
+  user:
+    client-certificate: cert.pem
+    client-key: key.pem
+`
+
+func TestCurrentCluster(t *testing.T) {
+	kubeConfig, err := ParseKubeConfig([]byte(multiClusterKubeConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, err := kubeConfig.CurrentCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Server != "https://172.99.79.252:6443" {
+		t.Error("expected the server of the current context, got", cluster.Server)
+	}
+
+	kubeConfig.CurrentContext = "missing"
+	if _, err = kubeConfig.CurrentCluster(); err == nil {
+		t.Error("expected an error for an undefined current-context")
+	}
+}
+
+func TestParseHostUsesCurrentContext(t *testing.T) {
+	creds := newTestBundle(map[string]string{"kubectl.config": multiClusterKubeConfig})
+	host, err := creds.ParseHost()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "172.99.79.252:6443" {
+		t.Error("expected the host of the current context, got", host)
+	}
+}
+
+func TestKubeConfigParseError(t *testing.T) {
+	creds := newTestBundle(map[string]string{"kubectl.config": "apiVersion: v1\nclusters:\n- name: a\n  cluster: [\n"})
+	_, err := creds.KubeConfig()
+	parseErr, ok := errors.Cause(err).(ParseError)
+	if !ok {
+		t.Fatal("expected a ParseError, got", err)
+	}
+	if parseErr.File != "kubectl.config" || parseErr.Line == 0 {
+		t.Error("expected the error to name the file and line, got", parseErr)
+	}
+}
+
+const existingKubeConfig = `apiVersion: v1
+kind: Config
+preferences:
+  colors: true
+clusters:
+- name: minikube
+  cluster:
+    server: https://192.168.99.100:8443
+users:
+- name: minikube
+  user:
+    auth-provider:
+      name: oidc
+contexts:
+- name: minikube
+  context:
+    cluster: minikube
+    user: minikube
+current-context: minikube
+`
+
+func newTestKubernetesBundle(name string) *CredentialsBundle {
+	return newTestBundle(map[string]string{
+		"kubectl.config": kubernetesKubeConfig,
+		"kubectl.env":    "export KUBECONFIG=$(pwd)/kubectl.config\nexport CARINA_CLUSTER_NAME=" + name + "\n",
+		"ca.pem":         "fake-ca",
+		"cert.pem":       "fake-cert",
+		"key.pem":        "fake-key",
+	})
+}
+
+func writeTestKubeConfig(t *testing.T, contents string) string {
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(configPath, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func readTestKubeConfig(t *testing.T, configPath string) *KubeConfig {
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeConfig, err := ParseKubeConfig(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kubeConfig
+}
+
+func TestMergeKubeConfig(t *testing.T) {
+	configPath := writeTestKubeConfig(t, existingKubeConfig)
+	creds := newTestKubernetesBundle("mycluster")
+
+	name, err := MergeKubeConfig(creds, &KubeConfigMergeOpts{Path: configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "mycluster" {
+		t.Error("expected entries to be named after the cluster, got", name)
+	}
+
+	kubeConfig := readTestKubeConfig(t, configPath)
+	if kubeConfig.CurrentContext != "minikube" {
+		t.Error("expected the current context to be unchanged, got", kubeConfig.CurrentContext)
+	}
+	if kubeConfig.Extra["preferences"] == nil || kubeConfig.FindUser("minikube").Extra["auth-provider"] == nil {
+		t.Error("expected existing settings to be preserved")
+	}
+
+	cluster := kubeConfig.FindCluster("mycluster")
+	if cluster == nil || cluster.Server != "https://172.99.79.252:6443" {
+		t.Fatal("expected the merged cluster, got", cluster)
+	}
+	if cluster.CertificateAuthority != "" || cluster.CertificateAuthorityData != base64.StdEncoding.EncodeToString([]byte("fake-ca")) {
+		t.Error("expected the CA to be embedded, got", cluster)
+	}
+	user := kubeConfig.FindUser("mycluster")
+	if user == nil || user.ClientKeyData != base64.StdEncoding.EncodeToString([]byte("fake-key")) {
+		t.Error("expected the client key to be embedded, got", user)
+	}
+	context := kubeConfig.FindContext("mycluster")
+	if context == nil || context.Cluster != "mycluster" || context.User != "mycluster" {
+		t.Error("expected the merged context, got", context)
+	}
+}
+
+func TestMergeKubeConfigConflicts(t *testing.T) {
+	configPath := writeTestKubeConfig(t, existingKubeConfig)
+	creds := newTestKubernetesBundle("minikube")
+
+	_, err := MergeKubeConfig(creds, &KubeConfigMergeOpts{Path: configPath})
+	if err == nil {
+		t.Error("expected an error when the name is already used")
+	}
+
+	name, err := MergeKubeConfig(creds, &KubeConfigMergeOpts{Path: configPath, Conflict: KubeConfigConflictRename, SwitchContext: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "minikube-2" {
+		t.Error("expected the entries to be renamed, got", name)
+	}
+	if kubeConfig := readTestKubeConfig(t, configPath); kubeConfig.CurrentContext != "minikube-2" {
+		t.Error("expected the current context to be switched, got", kubeConfig.CurrentContext)
+	}
+
+	_, err = MergeKubeConfig(creds, &KubeConfigMergeOpts{Path: configPath, Conflict: KubeConfigConflictOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeConfig := readTestKubeConfig(t, configPath)
+	if len(kubeConfig.Clusters) != 2 || kubeConfig.FindCluster("minikube").Server != "https://172.99.79.252:6443" {
+		t.Error("expected the existing entry to be replaced, got", kubeConfig.Clusters)
+	}
+}
+
+func TestRemoveKubeConfig(t *testing.T) {
+	configPath := writeTestKubeConfig(t, existingKubeConfig)
+	creds := newTestKubernetesBundle("mycluster")
+
+	_, err := MergeKubeConfig(creds, &KubeConfigMergeOpts{Path: configPath, SwitchContext: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RemoveKubeConfig("mycluster", configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubeConfig := readTestKubeConfig(t, configPath)
+	if kubeConfig.hasEntry("mycluster") {
+		t.Error("expected the entries to be removed")
+	}
+	if kubeConfig.CurrentContext != "" {
+		t.Error("expected the current context to be cleared, got", kubeConfig.CurrentContext)
+	}
+	if kubeConfig.FindCluster("minikube") == nil {
+		t.Error("expected other entries to be preserved")
+	}
+}
+
+func TestMergeKubeConfigSymlink(t *testing.T) {
+	target := writeTestKubeConfig(t, existingKubeConfig)
+	if err := os.Chmod(target, 0640); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := os.Symlink(target, configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := MergeKubeConfig(newTestKubernetesBundle("mycluster"), &KubeConfigMergeOpts{Path: configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("expected the symlink to be preserved")
+	}
+	info, err = os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected the permissions to be preserved, got %o", info.Mode().Perm())
+	}
+	if !readTestKubeConfig(t, target).hasEntry("mycluster") {
+		t.Error("expected the credentials to be merged into the symlink target")
+	}
+}
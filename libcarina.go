@@ -1,8 +1,8 @@
 package libcarina
 
 import (
-	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +11,7 @@ import (
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rackspace/gophercloud"
@@ -26,7 +27,55 @@ type CarinaClient struct {
 	Username  string
 	Token     string
 	Endpoint  string
+	Region    string
 	UserAgent string
+
+	// Proxy routes connections through a proxy, and is copied to the credentials bundles returned by GetCredentials
+	Proxy *ProxyConfig
+
+	// Metrics records measurements of the requests to Carina, defaults to discarding them
+	Metrics Metrics
+
+	// Tracer creates a span for each operation, with a child span for each request to Carina, defaults to no tracing
+	Tracer Tracer
+
+	// ClusterIndexTTL is how long the cluster names and ids from List are reused to resolve the cluster
+	// passed to other operations, defaults to 0 which lists the clusters again for each operation.
+	// A name which isn't in the index is looked up again before the operation fails.
+	ClusterIndexTTL time.Duration
+
+	// ListPageSize is how many clusters List, and the cluster name lookups, request at a time.
+	// Defaults to DefaultListPageSize, a negative size lists every cluster in a single request.
+	ListPageSize int
+
+	index clusterIndex
+
+	middleware []Middleware
+}
+
+// ClientOpts defines the optional settings when creating a CarinaClient
+type ClientOpts struct {
+	// Proxy routes connections to Carina, the identity service and clusters through a proxy, see ProxyConfig
+	Proxy *ProxyConfig
+
+	// Transport makes the requests to Carina and the identity service, defaults to an http.Transport which uses Proxy.
+	// Set it to observe or replay requests, e.g. with carinatest.Recorder.
+	Transport http.RoundTripper
+
+	// Logger records each request to Carina and the identity service, with tokens, API keys and credentials redacted
+	Logger Logger
+
+	// Metrics records measurements of the requests to Carina, see CarinaClient.Metrics
+	Metrics Metrics
+
+	// Tracer traces the requests to Carina, see CarinaClient.Tracer
+	Tracer Tracer
+
+	// ClusterIndexTTL caches the cluster names and ids, see CarinaClient.ClusterIndexTTL
+	ClusterIndexTTL time.Duration
+
+	// ListPageSize is how many clusters are requested at a time, see CarinaClient.ListPageSize
+	ListPageSize int
 }
 
 // HTTPErr is returned when API requests are not successful
@@ -119,11 +168,42 @@ func (err HTTPErr) Error() string {
 
 // NewClient create an authenticated CarinaClient
 func NewClient(username string, apikey string, region string, authEndpointOverride string, cachedToken string, cachedEndpoint string) (*CarinaClient, error) {
+	return NewClientWithOptions(username, apikey, region, authEndpointOverride, cachedToken, cachedEndpoint, nil)
+}
+
+// NewClientWithOptions create an authenticated CarinaClient, using the optional settings in opts
+func NewClientWithOptions(username string, apikey string, region string, authEndpointOverride string, cachedToken string, cachedEndpoint string, opts *ClientOpts) (*CarinaClient, error) {
+	if opts == nil {
+		opts = &ClientOpts{}
+	}
+
 	authEndpoint := rackspace.RackspaceUSIdentity
 	if authEndpointOverride != "" {
 		authEndpoint = authEndpointOverride
 	}
 
+	transport := opts.Transport
+	if transport == nil {
+		transport = opts.Proxy.newTransport()
+	}
+	if opts.Logger != nil {
+		transport = &loggingTransport{next: transport, logger: opts.Logger}
+	}
+
+	c := &CarinaClient{
+		Client:          &http.Client{Transport: transport},
+		Username:        username,
+		Token:           cachedToken,
+		Endpoint:        cachedEndpoint,
+		Region:          region,
+		UserAgent:       UserAgentPrefix,
+		Proxy:           opts.Proxy,
+		Metrics:         opts.Metrics,
+		Tracer:          opts.Tracer,
+		ClusterIndexTTL: opts.ClusterIndexTTL,
+		ListPageSize:    opts.ListPageSize,
+	}
+
 	verifyToken := func() error {
 		req, err := http.NewRequest("HEAD", authEndpoint+"tokens/"+cachedToken, nil)
 		if err != nil {
@@ -134,8 +214,7 @@ func NewClient(username string, apikey string, region string, authEndpointOverri
 		req.Header.Add("X-Auth-Token", cachedToken)
 		req.Header.Add("User-Agent", UserAgentPrefix)
 
-		httpClient := &http.Client{}
-		resp, err := httpClient.Do(req)
+		resp, err := c.Client.Do(req)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -150,18 +229,29 @@ func NewClient(username string, apikey string, region string, authEndpointOverri
 	}
 
 	// Attempt to authenticate with the cached token first, falling back on the apikey
-	if cachedToken == "" || verifyToken() != nil {
+	authenticate := cachedToken == ""
+	if !authenticate && verifyToken() != nil {
+		c.metrics().IncReauthentication()
+		authenticate = true
+	}
+	if authenticate {
 		ao := &gophercloud.AuthOptions{
 			Username:         username,
 			APIKey:           apikey,
 			IdentityEndpoint: authEndpoint,
 		}
 
-		provider, err := rackspace.AuthenticatedClient(*ao)
+		provider, err := rackspace.NewClient(authEndpoint)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		provider.HTTPClient = *c.Client
+
+		err = rackspace.Authenticate(provider, *ao)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		cachedToken = provider.TokenID
+		c.Token = provider.TokenID
 
 		eo := gophercloud.EndpointOpts{Region: region}
 		eo.ApplyDefaults(CarinaEndpointType)
@@ -170,20 +260,26 @@ func NewClient(username string, apikey string, region string, authEndpointOverri
 			return nil, errors.WithStack(err)
 		}
 
-		cachedEndpoint = strings.TrimRight(url, "/")
+		c.Endpoint = strings.TrimRight(url, "/")
 	}
 
-	return &CarinaClient{
-		Client:    &http.Client{},
-		Username:  username,
-		Token:     cachedToken,
-		Endpoint:  cachedEndpoint,
-		UserAgent: UserAgentPrefix,
-	}, nil
+	return c, nil
 }
 
 // NewRequest handles a request using auth used by Carina
 func (c *CarinaClient) NewRequest(method string, uri string, body io.Reader) (*http.Response, error) {
+	return c.NewRequestContext(context.Background(), method, uri, body)
+}
+
+// NewRequestContext is NewRequest with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) NewRequestContext(ctx context.Context, method string, uri string, body io.Reader) (*http.Response, error) {
+	ctx, end := c.startOperation(ctx, "NewRequest")
+	resp, err := c.newRequest(ctx, method, uri, body)
+	return resp, end(err)
+}
+
+// newRequest makes a request on behalf of the operation in ctx, e.g. List
+func (c *CarinaClient) newRequest(ctx context.Context, method string, uri string, body io.Reader) (*http.Response, error) {
 	req, err := http.NewRequest(method, c.Endpoint+uri, body)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -195,10 +291,21 @@ func (c *CarinaClient) NewRequest(method string, uri string, body io.Reader) (*h
 	req.Header.Set("User-Agent", c.UserAgent)
 	req.Header.Add("API-Version", CarinaEndpointType+" "+SupportedAPIVersion)
 
-	resp, err := c.Client.Do(req)
+	ctx, span := c.tracer().Start(ctx, "HTTP "+method)
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", req.URL.String())
+	c.tracer().Inject(ctx, req.Header)
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := c.doer().Do(req)
 	if err != nil {
+		c.metrics().ObserveRequest(OperationFromContext(ctx), 0, time.Since(start))
+		endSpan(span, err)
 		return nil, errors.WithStack(err)
 	}
+	c.metrics().ObserveRequest(OperationFromContext(ctx), resp.StatusCode, time.Since(start))
+	span.SetAttribute("http.status_code", resp.StatusCode)
 
 	if resp.StatusCode >= 400 {
 		err := HTTPErr{
@@ -210,29 +317,24 @@ func (c *CarinaClient) NewRequest(method string, uri string, body io.Reader) (*h
 		defer resp.Body.Close()
 		b, _ := ioutil.ReadAll(resp.Body)
 		err.Body = string(b)
+		endSpan(span, err)
 		return nil, errors.WithStack(err)
 	}
 
+	endSpan(span, nil)
 	return resp, nil
 }
 
 // List the current clusters
 func (c *CarinaClient) List() ([]*Cluster, error) {
-	resp, err := c.NewRequest("GET", "/clusters", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	var result struct {
-		Clusters []*Cluster `json:"clusters"`
-	}
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+	return c.ListContext(context.Background())
+}
 
-	return result.Clusters, nil
+// ListContext is List with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) ListContext(ctx context.Context) ([]*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "List")
+	clusters, err := c.list(ctx)
+	return clusters, end(err)
 }
 
 func clusterFromResponse(resp *http.Response, err error) (*Cluster, error) {
@@ -250,71 +352,24 @@ func clusterFromResponse(resp *http.Response, err error) (*Cluster, error) {
 }
 
 func isClusterID(token string) bool {
-	r := regexp.MustCompile("^[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}$")
+	r := regexp.MustCompile("^(?i)[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}$")
 	return r.MatchString(token)
 }
 
-func (c *CarinaClient) lookupClusterName(token string) (string, error) {
-	if !isClusterID(token) {
-		return token, nil
-	}
-
-	clusters, err := c.List()
-	if err != nil {
-		return "", err
-	}
-
-	var name string
-	for _, cluster := range clusters {
-		if strings.ToLower(cluster.ID) == strings.ToLower(token) {
-			name = cluster.Name
-			break
-		}
-	}
-
-	if name == "" {
-		return "", HTTPErr{
-			StatusCode: http.StatusNotFound,
-			Status:     "404 NOT FOUND",
-			Body:       `{"message": "Cluster "` + token + ` not found"}`}
-	}
-
-	return name, nil
+// ListClusterTypes returns a list of cluster types
+func (c *CarinaClient) ListClusterTypes() ([]*ClusterType, error) {
+	return c.ListClusterTypesContext(context.Background())
 }
 
-func (c *CarinaClient) lookupClusterID(token string) (string, error) {
-	if isClusterID(token) {
-		return token, nil
-	}
-
-	clusters, err := c.List()
-	if err != nil {
-		return "", err
-	}
-
-	var id string
-	for _, cluster := range clusters {
-		if strings.ToLower(cluster.Name) == strings.ToLower(token) {
-			if id != "" {
-				return "", fmt.Errorf("The cluster (%s) is not unique. Retry the request using the cluster id", token)
-			}
-			id = cluster.ID
-		}
-	}
-
-	if id == "" {
-		return "", HTTPErr{
-			StatusCode: http.StatusNotFound,
-			Status:     "404 NOT FOUND",
-			Body:       `{"message": "Cluster "` + token + ` not found"}`}
-	}
-
-	return id, nil
+// ListClusterTypesContext is ListClusterTypes with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) ListClusterTypesContext(ctx context.Context) ([]*ClusterType, error) {
+	ctx, end := c.startOperation(ctx, "ListClusterTypes")
+	types, err := c.listClusterTypes(ctx)
+	return types, end(err)
 }
 
-// ListClusterTypes returns a list of cluster types
-func (c *CarinaClient) ListClusterTypes() ([]*ClusterType, error) {
-	resp, err := c.NewRequest("GET", "/cluster_types", nil)
+func (c *CarinaClient) listClusterTypes(ctx context.Context) ([]*ClusterType, error) {
+	resp, err := c.newRequest(ctx, "GET", "/cluster_types", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -333,31 +388,69 @@ func (c *CarinaClient) ListClusterTypes() ([]*ClusterType, error) {
 
 // Get a cluster by cluster by its name or id
 func (c *CarinaClient) Get(token string) (*Cluster, error) {
-	id, err := c.lookupClusterID(token)
+	return c.GetContext(context.Background(), token)
+}
+
+// GetContext is Get with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) GetContext(ctx context.Context, token string) (*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "Get")
+	cluster, err := c.get(ctx, token)
+	return cluster, end(err)
+}
+
+func (c *CarinaClient) get(ctx context.Context, token string) (*Cluster, error) {
+	ref, err := c.resolveCluster(ctx, token, false)
 	if err != nil {
 		return nil, err
 	}
 
+	return c.getByID(ctx, ref.ID)
+}
+
+func (c *CarinaClient) getByID(ctx context.Context, id string) (*Cluster, error) {
 	uri := path.Join("/clusters", id)
-	resp, err := c.NewRequest("GET", uri, nil)
+	resp, err := c.newRequest(ctx, "GET", uri, nil)
 	return clusterFromResponse(resp, err)
 }
 
 // Create a new cluster with cluster options
 func (c *CarinaClient) Create(clusterOpts *CreateClusterOpts) (*Cluster, error) {
+	return c.CreateContext(context.Background(), clusterOpts)
+}
+
+// CreateContext is Create with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) CreateContext(ctx context.Context, clusterOpts *CreateClusterOpts) (*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "Create")
+	cluster, err := c.create(ctx, clusterOpts)
+	return cluster, end(err)
+}
+
+func (c *CarinaClient) create(ctx context.Context, clusterOpts *CreateClusterOpts) (*Cluster, error) {
 	clusterOptsJSON, err := json.Marshal(clusterOpts)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	body := bytes.NewReader(clusterOptsJSON)
-	resp, err := c.NewRequest("POST", "/clusters", body)
+	resp, err := c.newRequest(ctx, "POST", "/clusters", body)
+	c.InvalidateClusterIndex()
 	return clusterFromResponse(resp, err)
 }
 
 // Resize a cluster with resize task options
 func (c *CarinaClient) Resize(token string, nodes int) (*Cluster, error) {
-	id, err := c.lookupClusterID(token)
+	return c.ResizeContext(context.Background(), token, nodes)
+}
+
+// ResizeContext is Resize with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) ResizeContext(ctx context.Context, token string, nodes int) (*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "Resize")
+	cluster, err := c.resize(ctx, token, nodes)
+	return cluster, end(err)
+}
+
+func (c *CarinaClient) resize(ctx context.Context, token string, nodes int) (*Cluster, error) {
+	ref, err := c.resolveCluster(ctx, token, false)
 	if err != nil {
 		return nil, err
 	}
@@ -369,105 +462,129 @@ func (c *CarinaClient) Resize(token string, nodes int) (*Cluster, error) {
 	}
 
 	body := bytes.NewReader(resizeOptsJSON)
-	uri := path.Join("/clusters", id, "tasks")
-	resp, err := c.NewRequest("POST", uri, body)
+	uri := path.Join("/clusters", ref.ID, "tasks")
+	resp, err := c.newRequest(ctx, "POST", uri, body)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return c.Get(token)
+	return c.getByID(ctx, ref.ID)
 }
 
 // GetCredentials returns a Credentials struct for the given cluster name
 func (c *CarinaClient) GetCredentials(token string) (*CredentialsBundle, error) {
-	id, err := c.lookupClusterID(token)
-	if err != nil {
-		return nil, err
-	}
+	return c.GetCredentialsWithOptions(token, nil)
+}
+
+// GetCredentialsContext is GetCredentials with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) GetCredentialsContext(ctx context.Context, token string) (*CredentialsBundle, error) {
+	return c.GetCredentialsWithOptionsContext(ctx, token, nil)
+}
+
+// GetCredentialsWithOptions returns a Credentials struct for the given cluster name, limiting what is extracted from the zip as specified by opts
+func (c *CarinaClient) GetCredentialsWithOptions(token string, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	return c.GetCredentialsWithOptionsContext(context.Background(), token, opts)
+}
+
+// GetCredentialsWithOptionsContext is GetCredentialsWithOptions with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) GetCredentialsWithOptionsContext(ctx context.Context, token string, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	ctx, end := c.startOperation(ctx, "GetCredentials")
+	creds, err := c.getCredentials(ctx, token, opts)
+	return creds, end(err)
+}
 
-	name, err := c.lookupClusterName(token)
+func (c *CarinaClient) getCredentials(ctx context.Context, token string, opts *GetCredentialsOpts) (*CredentialsBundle, error) {
+	ref, err := c.resolveCluster(ctx, token, true)
 	if err != nil {
 		return nil, err
 	}
 
-	uri := path.Join("/clusters", id, "credentials/zip")
-	resp, err := c.NewRequest("GET", uri, nil)
+	uri := path.Join("/clusters", ref.ID, "credentials/zip")
+	resp, err := c.newRequest(ctx, "GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// Read the body as a zip file
-	buf := &bytes.Buffer{}
-	_, err = io.Copy(buf, resp.Body)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if resp.ContentLength > opts.maxTotalSize() {
+		return nil, fmt.Errorf("Invalid credentials bundle. The zip exceeds the maximum size of %d bytes", opts.maxTotalSize())
 	}
 
-	b := bytes.NewReader(buf.Bytes())
-	zipr, err := zip.NewReader(b, int64(b.Len()))
+	creds, err := readCredentialsZip(resp.Body, opts)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
+	creds.Proxy = c.Proxy
 
-	// Fetch the contents for each file in the zipfile
-	creds := NewCredentialsBundle()
-	for _, zf := range zipr.File {
-		_, fname := path.Split(zf.Name)
-		fi := zf.FileInfo()
-
-		if fi.IsDir() {
-			// Explicitly skip past directories (the UUID directory from a previous release)
-			continue
-		}
-
-		rc, err := zf.Open()
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		b, err := ioutil.ReadAll(rc)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		creds.Files[fname] = b
+	err = creds.AppendEnv([]EnvVar{
+		{"CARINA_CLUSTER_NAME", ref.Name},
+		{"CARINA_CLUSTER_ID", ref.ID},
+		{"CARINA_REGION", c.Region},
+		{"CARINA_ENDPOINT", c.Endpoint},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	appendClusterName(name, creds)
-
 	return creds, nil
 }
 
-// Set the CLUSTER_NAME environment variable in the scripts
-func appendClusterName(name string, creds *CredentialsBundle) {
-	addStmt := func(fileName string, stmt string) {
-		script := creds.Files[fileName]
-		script = append(script, []byte(stmt)...)
-		creds.Files[fileName] = script
-	}
-
-	for fileName := range creds.Files {
+// AppendEnv sets environment variables, such as the CARINA_* cluster metadata, at the end of each shell script in the bundle.
+// The values are quoted for each shell, and variables with an empty value are skipped.
+func (creds *CredentialsBundle) AppendEnv(vars []EnvVar) error {
+	for fileName, script := range creds.Files {
+		var shell Shell
 		switch fileName {
 		case "docker.env", "kubectl.env":
-			addStmt(fileName, fmt.Sprintf("\nexport CARINA_CLUSTER_NAME=%s\n", name))
+			shell = BashShell
 		case "docker.fish", "kubectl.fish":
-			addStmt(fileName, fmt.Sprintf("\nset -x CARINA_CLUSTER_NAME %s\n", name))
+			shell = FishShell
 		case "docker.ps1", "kubectl.ps1":
-			addStmt(fileName, fmt.Sprintf("\n$env:CARINA_CLUSTER_NAME=\"%s\"\n", name))
+			shell = PowerShell
 		case "docker.cmd", "kubectl.cmd":
-			addStmt(fileName, fmt.Sprintf("\nset CARINA_CLUSTER_NAME=%s\n", name))
+			shell = CmdShell
+		default:
+			continue
+		}
+
+		script = append(script, '\n')
+		for _, v := range vars {
+			if v.Value == "" {
+				continue
+			}
+			stmt, err := renderEnvVar(shell, v)
+			if err != nil {
+				return errors.Wrapf(err, "Unable to update %s", fileName)
+			}
+			script = append(script, stmt...)
+			script = append(script, '\n')
 		}
+		creds.Files[fileName] = script
 	}
+	return nil
 }
 
 // Delete nukes a cluster out of existence
 func (c *CarinaClient) Delete(token string) (*Cluster, error) {
-	id, err := c.lookupClusterID(token)
+	return c.DeleteContext(context.Background(), token)
+}
+
+// DeleteContext is Delete with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) DeleteContext(ctx context.Context, token string) (*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "Delete")
+	cluster, err := c.deleteCluster(ctx, token)
+	return cluster, end(err)
+}
+
+func (c *CarinaClient) deleteCluster(ctx context.Context, token string) (*Cluster, error) {
+	ref, err := c.resolveCluster(ctx, token, false)
 	if err != nil {
 		return nil, err
 	}
 
-	uri := path.Join("/clusters", id)
-	resp, err := c.NewRequest("DELETE", uri, nil)
+	uri := path.Join("/clusters", ref.ID)
+	resp, err := c.newRequest(ctx, "DELETE", uri, nil)
+	c.InvalidateClusterIndex()
 	return clusterFromResponse(resp, err)
 }
@@ -3,6 +3,7 @@ package libcarina
 import (
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 
 	"fmt"
@@ -204,3 +205,96 @@ func TestMicroversionUnsupportedGetCredentials(t *testing.T) {
 	}
 	assertMicroversionUnsupportedHandled(t, err)
 }
+
+func TestAppendClusterMetadata(t *testing.T) {
+	name := "evil `touch pwned` $(reboot) \"quoted\" 'single' & %PATH%"
+	creds := newTestBundle(map[string]string{
+		"docker.env":  "export DOCKER_HOST=tcp://172.99.65.11:2376\n",
+		"docker.fish": "",
+		"docker.ps1":  "",
+		"docker.cmd":  "",
+		"ca.pem":      "fake-ca",
+	})
+
+	err := creds.AppendEnv([]EnvVar{
+		{"CARINA_CLUSTER_NAME", name},
+		{"CARINA_CLUSTER_ID", "9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c"},
+		{"CARINA_REGION", ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := parseShellEnv("docker.env", creds.Files["docker.env"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["CARINA_CLUSTER_NAME"] != name || env["CARINA_CLUSTER_ID"] != "9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c" {
+		t.Error("unexpected cluster metadata in docker.env", env)
+	}
+	if _, ok := env["CARINA_REGION"]; ok {
+		t.Error("expected empty values to be omitted")
+	}
+	if creds.ClusterName() != name {
+		t.Error("expected the cluster name to be read back from docker.env, got", creds.ClusterName())
+	}
+
+	expected := map[string]string{
+		"docker.fish": `set -gx CARINA_CLUSTER_NAME 'evil ` + "`touch pwned`" + ` $(reboot) "quoted" \'single\' & %PATH%'`,
+		"docker.ps1":  `$env:CARINA_CLUSTER_NAME = 'evil ` + "`touch pwned`" + ` $(reboot) "quoted" ''single'' & %PATH%'`,
+		"docker.cmd":  `set CARINA_CLUSTER_NAME=evil ` + "`touch pwned`" + ` $^(reboot^) ^"quoted^" 'single' ^& %%PATH%%`,
+	}
+	for fileName, stmt := range expected {
+		lines := strings.Split(string(creds.Files[fileName]), "\n")
+		if len(lines) < 2 || lines[1] != stmt {
+			t.Errorf("unexpected %s\nexpected: %s\nactual:   %s", fileName, stmt, creds.Files[fileName])
+		}
+	}
+
+	if string(creds.Files["ca.pem"]) != "fake-ca" {
+		t.Error("expected non-script files to be untouched")
+	}
+}
+
+func TestGetCredentials(t *testing.T) {
+	zipBytes := newTestZip(t,
+		testZipEntry{"docker.env", swarmDockerEnv},
+		testZipEntry{"ca.pem", "fake-ca"})
+
+	mockCarina, mockIdentity := createMockCarina(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/clusters":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintln(w, `{"clusters":[{"id":"9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c","name":"my cluster"}]}`)
+		case "/clusters/9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c/credentials/zip":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write(zipBytes)
+		default:
+			w.WriteHeader(404)
+		}
+	})
+	defer mockCarina.Close()
+	defer mockIdentity.Close()
+
+	carinaClient, err := createMockCarinaClient(mockIdentity.URL+"/v2.0/", mockCarina.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := carinaClient.GetCredentials("my cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClusterName() != "my cluster" {
+		t.Error("expected the cluster name to be recorded, got", creds.ClusterName())
+	}
+	host, err := creds.ParseHost()
+	if err != nil || host != "172.99.65.11:2376" {
+		t.Error("unexpected host", host, err)
+	}
+
+	_, err = carinaClient.GetCredentialsWithOptions("my cluster", &GetCredentialsOpts{MaxFileSize: 4})
+	if err == nil {
+		t.Error("expected the file size limit to be enforced")
+	}
+}
@@ -0,0 +1,242 @@
+package libcarina
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ListClustersOpts defines the set of parameters when listing clusters.
+// Limit, Marker, Status and ClusterTypeID are sent to Carina. Every filter is also applied to the results,
+// so filters which Carina ignores, such as NamePrefix and COE, still apply.
+type ListClustersOpts struct {
+	// Limit is the maximum number of clusters requested per page, defaults to 0 which requests every cluster at once
+	Limit int
+
+	// Marker is the id of the last cluster on the previous page, the page starts after it
+	Marker string
+
+	// NamePrefix only includes clusters whose names start with the prefix, ignoring case
+	NamePrefix string
+
+	// Status only includes clusters with the status, e.g. active
+	Status string
+
+	// COE only includes clusters using the container orchestration engine, e.g. swarm
+	COE COE
+
+	// ClusterTypeID only includes clusters of the cluster type
+	ClusterTypeID int
+}
+
+// ClusterPage is a page of clusters returned by ListWithOptions
+type ClusterPage struct {
+	// Clusters on the page which match the filters
+	Clusters []*Cluster
+
+	// NextMarker is the Marker for the following page, or empty when this is the last page
+	NextMarker string
+}
+
+// DefaultListPageSize is how many clusters are requested at a time by List, see CarinaClient.ListPageSize
+const DefaultListPageSize = 100
+
+func (c *CarinaClient) list(ctx context.Context) ([]*Cluster, error) {
+	pageSize := c.ListPageSize
+	if pageSize == 0 {
+		pageSize = DefaultListPageSize
+	} else if pageSize < 0 {
+		pageSize = 0
+	}
+
+	// Walk the pages within the operation in ctx, rather than starting a new operation for each page
+	it := &ClusterIterator{
+		fetch: func(opts *ListClustersOpts) (*ClusterPage, error) {
+			return c.listPage(ctx, opts)
+		},
+		opts: ListClustersOpts{Limit: pageSize},
+	}
+	clusters := []*Cluster{}
+	for it.Next() {
+		clusters = append(clusters, it.Cluster())
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	c.index.store(clusters, c.ClusterIndexTTL)
+	return clusters, nil
+}
+
+// ListWithOptions lists a page of clusters which match the filters in opts
+func (c *CarinaClient) ListWithOptions(opts *ListClustersOpts) (*ClusterPage, error) {
+	return c.ListWithOptionsContext(context.Background(), opts)
+}
+
+// ListWithOptionsContext is ListWithOptions with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) ListWithOptionsContext(ctx context.Context, opts *ListClustersOpts) (*ClusterPage, error) {
+	ctx, end := c.startOperation(ctx, "List")
+	page, err := c.listPage(ctx, opts)
+	return page, end(err)
+}
+
+func (c *CarinaClient) listPage(ctx context.Context, opts *ListClustersOpts) (*ClusterPage, error) {
+	if opts == nil {
+		opts = &ListClustersOpts{}
+	}
+
+	query := url.Values{}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Marker != "" {
+		query.Set("marker", opts.Marker)
+	}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.ClusterTypeID != 0 {
+		query.Set("cluster_type_id", strconv.Itoa(opts.ClusterTypeID))
+	}
+
+	uri := "/clusters"
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	resp, err := c.newRequest(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Clusters []*Cluster `json:"clusters"`
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	clusters := result.Clusters
+	ignoredMarker := false
+	if opts.Marker != "" {
+		// Skip the clusters up to the marker when Carina ignored it and started from the beginning
+		for i, cluster := range clusters {
+			if strings.EqualFold(cluster.ID, opts.Marker) {
+				clusters = clusters[i+1:]
+				ignoredMarker = true
+				break
+			}
+		}
+	}
+
+	page := &ClusterPage{}
+	for _, cluster := range clusters {
+		if opts.matches(cluster) {
+			page.Clusters = append(page.Clusters, cluster)
+		}
+	}
+
+	// A full page means there may be more
+	if opts.Limit > 0 && len(result.Clusters) == opts.Limit {
+		if ignoredMarker {
+			// Carina honoured the limit but not the marker, so the following pages can never be requested.
+			// List the remaining clusters in a single request instead of stopping early.
+			unpaginated := *opts
+			unpaginated.Limit = 0
+			return c.listPage(ctx, &unpaginated)
+		}
+		page.NextMarker = result.Clusters[len(result.Clusters)-1].ID
+	}
+
+	return page, nil
+}
+
+func (opts *ListClustersOpts) matches(cluster *Cluster) bool {
+	if opts.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(cluster.Name), strings.ToLower(opts.NamePrefix)) {
+		return false
+	}
+	if opts.Status != "" && !strings.EqualFold(cluster.Status, opts.Status) {
+		return false
+	}
+	if opts.COE != "" && (cluster.Type == nil || !strings.EqualFold(cluster.Type.COE, string(opts.COE))) {
+		return false
+	}
+	if opts.ClusterTypeID != 0 && (cluster.Type == nil || cluster.Type.ID != opts.ClusterTypeID) {
+		return false
+	}
+	return true
+}
+
+// ClusterIterator walks the clusters which match a ListClustersOpts, requesting each page only when it is needed
+//
+//	it := client.IterateClusters(&libcarina.ListClustersOpts{Limit: 100, Status: "active"})
+//	for it.Next() {
+//		fmt.Println(it.Cluster().Name)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ClusterIterator struct {
+	fetch   func(opts *ListClustersOpts) (*ClusterPage, error)
+	opts    ListClustersOpts
+	page    []*Cluster
+	current *Cluster
+	done    bool
+	err     error
+}
+
+// IterateClusters returns an iterator over the clusters which match opts, starting at opts.Marker
+func (c *CarinaClient) IterateClusters(opts *ListClustersOpts) *ClusterIterator {
+	return c.IterateClustersContext(context.Background(), opts)
+}
+
+// IterateClustersContext is IterateClusters with a context, which is the parent of each page's span and cancels its requests
+func (c *CarinaClient) IterateClustersContext(ctx context.Context, opts *ListClustersOpts) *ClusterIterator {
+	it := &ClusterIterator{
+		fetch: func(opts *ListClustersOpts) (*ClusterPage, error) {
+			return c.ListWithOptionsContext(ctx, opts)
+		},
+	}
+	if opts != nil {
+		it.opts = *opts
+	}
+	return it
+}
+
+// Next advances to the next cluster, returning false when there are no more clusters or an error occurred
+func (it *ClusterIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			it.current = nil
+			return false
+		}
+
+		page, err := it.fetch(&it.opts)
+		if err != nil {
+			it.err = err
+			continue
+		}
+		it.page = page.Clusters
+		it.opts.Marker = page.NextMarker
+		it.done = page.NextMarker == ""
+	}
+
+	it.current = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Cluster returns the current cluster
+func (it *ClusterIterator) Cluster() *Cluster {
+	return it.current
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *ClusterIterator) Err() error {
+	return it.err
+}
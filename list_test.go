@@ -0,0 +1,158 @@
+package libcarina
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// newTestListClient serves five clusters, honoring limit and marker only when set, and records each query
+func newTestListClient(t *testing.T, honorLimit bool, honorMarker bool) (*CarinaClient, *[]string) {
+	clusters := []*Cluster{
+		{ID: "a", Name: "ci-1", Status: "active", Type: &ClusterType{ID: 1, COE: "swarm"}},
+		{ID: "b", Name: "ci-2", Status: "creating", Type: &ClusterType{ID: 2, COE: "kubernetes"}},
+		{ID: "c", Name: "prod", Status: "active", Type: &ClusterType{ID: 2, COE: "kubernetes"}},
+		{ID: "d", Name: "CI-3", Status: "active", Type: &ClusterType{ID: 2, COE: "kubernetes"}},
+		{ID: "e", Name: "dev", Status: "active", Type: &ClusterType{ID: 1, COE: "swarm"}},
+	}
+
+	var queries []string
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		page := clusters
+		if marker := r.URL.Query().Get("marker"); honorMarker && marker != "" {
+			for i, cluster := range clusters {
+				if cluster.ID == marker {
+					page = clusters[i+1:]
+				}
+			}
+		}
+		if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); honorLimit && limit > 0 && limit < len(page) {
+			page = page[:limit]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"clusters": page})
+	})
+	return carinaClient, &queries
+}
+
+func clusterNames(clusters []*Cluster) string {
+	var names []string
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+	}
+	return fmt.Sprint(names)
+}
+
+func TestListWithOptions(t *testing.T) {
+	carinaClient, queries := newTestListClient(t, true, true)
+
+	page, err := carinaClient.ListWithOptions(&ListClustersOpts{Limit: 2, Marker: "a", Status: "active", COE: KubernetesCOE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusterNames(page.Clusters) != "[prod]" {
+		t.Error("expected the filters to be applied to the page, got", clusterNames(page.Clusters))
+	}
+	if page.NextMarker != "c" {
+		t.Error("expected the next marker to be the last cluster on the page, got", page.NextMarker)
+	}
+	if (*queries)[0] != "limit=2&marker=a&status=active" {
+		t.Error("expected only the filters supported by Carina to be sent, got", (*queries)[0])
+	}
+
+	page, err = carinaClient.ListWithOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Clusters) != 5 || page.NextMarker != "" {
+		t.Errorf("expected every cluster on a single page, got %s %q", clusterNames(page.Clusters), page.NextMarker)
+	}
+}
+
+func TestIterateClusters(t *testing.T) {
+	carinaClient, queries := newTestListClient(t, true, true)
+
+	it := carinaClient.IterateClusters(&ListClustersOpts{Limit: 2, NamePrefix: "ci-"})
+	if len(*queries) != 0 {
+		t.Fatal("expected no requests until the iterator is advanced")
+	}
+
+	var clusters []*Cluster
+	for it.Next() {
+		clusters = append(clusters, it.Cluster())
+		if len(clusters) == 1 && len(*queries) != 1 {
+			t.Error("expected only the first page to be requested, got", *queries)
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if clusterNames(clusters) != "[ci-1 ci-2 CI-3]" {
+		t.Error("expected the clusters matching the name prefix, got", clusterNames(clusters))
+	}
+	if len(*queries) != 3 {
+		t.Error("expected three pages to be requested, got", *queries)
+	}
+}
+
+func TestIterateClustersWithoutServerPagination(t *testing.T) {
+	carinaClient, queries := newTestListClient(t, false, false)
+
+	it := carinaClient.IterateClusters(&ListClustersOpts{Limit: 5, ClusterTypeID: 1})
+	var clusters []*Cluster
+	for it.Next() {
+		clusters = append(clusters, it.Cluster())
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if clusterNames(clusters) != "[ci-1 dev]" {
+		t.Error("expected the cluster type to be filtered client-side, got", clusterNames(clusters))
+	}
+	expected := []string{"cluster_type_id=1&limit=5", "cluster_type_id=1&limit=5&marker=e", "cluster_type_id=1&marker=e"}
+	if fmt.Sprint(*queries) != fmt.Sprint(expected) {
+		t.Errorf("expected iteration to stop once Carina ignored the marker, %v, got %v", expected, *queries)
+	}
+}
+
+func TestIterateClustersWithoutServerMarker(t *testing.T) {
+	carinaClient, queries := newTestListClient(t, true, false)
+
+	it := carinaClient.IterateClusters(&ListClustersOpts{Limit: 2})
+	var clusters []*Cluster
+	for it.Next() {
+		clusters = append(clusters, it.Cluster())
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if clusterNames(clusters) != "[ci-1 ci-2 prod CI-3 dev]" {
+		t.Error("expected the remaining clusters to be listed in a single request, got", clusterNames(clusters))
+	}
+	expected := []string{"limit=2", "limit=2&marker=b", "marker=b"}
+	if fmt.Sprint(*queries) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, *queries)
+	}
+}
+
+func TestListIsPaginated(t *testing.T) {
+	carinaClient, queries := newTestListClient(t, true, true)
+	carinaClient.ListPageSize = 2
+
+	clusters, err := carinaClient.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 5 {
+		t.Error("expected every cluster, got", clusterNames(clusters))
+	}
+	if len(*queries) != 3 || (*queries)[0] != "limit=2" {
+		t.Error("expected List to request a page at a time, got", *queries)
+	}
+
+	if _, err = carinaClient.Get("dev"); err != nil {
+		t.Error("expected the cluster name to be resolved across pages", err)
+	}
+}
@@ -0,0 +1,262 @@
+package libcarina
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LogLevel is the severity of a log entry
+type LogLevel int
+
+const (
+	// LogDebug entries include the request and response bodies
+	LogDebug LogLevel = iota
+
+	// LogInfo entries summarize each request
+	LogInfo
+
+	// LogError entries report requests which could not be sent
+	LogError
+)
+
+// String returns the name of the level, e.g. debug
+func (level LogLevel) String() string {
+	switch level {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(level))
+}
+
+// Logger receives structured log entries about the requests made to Carina and the identity service.
+// Implement it to adapt to a logging library, or use NewStdLogger.
+type Logger interface {
+	// Enabled reports whether entries at level are logged, so that bodies are only read when they are needed
+	Enabled(level LogLevel) bool
+
+	// Log records an entry, fields such as method, url and status describe the request
+	Log(level LogLevel, msg string, fields map[string]interface{})
+}
+
+// stdLogger writes log entries as key=value pairs to a standard library logger
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger creates a Logger which writes entries at level or above to logger,
+// e.g. level=info msg="carina request" method=GET status=200
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *stdLogger) Log(level LogLevel, msg string, fields map[string]interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := fmt.Sprintf("level=%s msg=%q", level, msg)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+		if strings.ContainsAny(value, " \"=\n") || value == "" {
+			value = fmt.Sprintf("%q", value)
+		}
+		entry += " " + key + "=" + value
+	}
+	l.logger.Print(entry)
+}
+
+// redactedValue replaces secrets in logged headers, URLs and bodies
+const redactedValue = "REDACTED"
+
+// sensitiveHeaders are never logged
+var sensitiveHeaders = []string{"X-Auth-Token", "Authorization"}
+
+// sensitiveBodyFields matches credentials in JSON bodies, e.g. the apiKey sent to the identity service
+var sensitiveBodyFields = regexp.MustCompile(`("(?:apiKey|password)"\s*:\s*")[^"]*(")`)
+
+// sensitiveTokenPath matches the token being validated, e.g. /v2.0/tokens/abc123
+var sensitiveTokenPath = regexp.MustCompile(`(/tokens/)[^/?]+`)
+
+// credentialsPath matches the credentials download, e.g. /clusters/abc123/credentials/zip, whose body is never logged
+var credentialsPath = regexp.MustCompile(`/clusters/[^/]+/credentials`)
+
+// loggingTransport logs each request made through it, redacting secrets
+type loggingTransport struct {
+	next   http.RoundTripper
+	logger Logger
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestURL := sensitiveTokenPath.ReplaceAllString(req.URL.String(), "${1}"+redactedValue)
+
+	if t.logger.Enabled(LogDebug) {
+		// RoundTrip must not modify the caller's request, so the body is replaced on a copy
+		req = req.Clone(req.Context())
+		body, err := peekBody(req.Header, &req.Body)
+		if err != nil {
+			return nil, err
+		}
+		t.logger.Log(LogDebug, "carina request body", map[string]interface{}{
+			"method":  req.Method,
+			"url":     requestURL,
+			"headers": redactHeaders(req.Header),
+			"body":    body,
+		})
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	duration := time.Since(start)
+
+	fields := map[string]interface{}{
+		"method":      req.Method,
+		"url":         requestURL,
+		"duration":    duration,
+		"api_version": req.Header.Get("API-Version"),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		t.logger.Log(LogError, "carina request failed", fields)
+		return resp, err
+	}
+
+	fields["status"] = resp.StatusCode
+	fields["request_id"] = resp.Header.Get(RequestIDHeader)
+	if fields["request_id"] == "" {
+		fields["request_id"] = req.Header.Get(RequestIDHeader)
+	}
+
+	// Error responses are small and include the Carina request ID, so read them even when bodies aren't logged
+	if resp.StatusCode >= 400 || t.logger.Enabled(LogDebug) {
+		// Credentials are redacted by path, as the Content-Type or encoding of the zip cannot be relied upon
+		body := "<credentials body redacted>"
+		if resp.StatusCode >= 400 || !credentialsPath.MatchString(req.URL.Path) {
+			body, err = peekBody(resp.Header, &resp.Body)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if fields["request_id"] == "" {
+			fields["request_id"] = carinaRequestID(body)
+		}
+		if t.logger.Enabled(LogDebug) {
+			t.logger.Log(LogDebug, "carina response body", map[string]interface{}{
+				"method":  req.Method,
+				"url":     requestURL,
+				"status":  resp.StatusCode,
+				"headers": redactHeaders(resp.Header),
+				"body":    body,
+			})
+		}
+	}
+
+	t.logger.Log(LogInfo, "carina request", fields)
+	return resp, nil
+}
+
+// peekBody reads a text body, replacing it so that it can still be read by the caller, and returns it with any secrets redacted.
+// Binary bodies, such as credentials zips, are left unread and summarized instead.
+func peekBody(header http.Header, body *io.ReadCloser) (string, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", nil
+	}
+	if isBinaryContent(header.Get("Content-Type")) {
+		return "<binary body redacted>", nil
+	}
+
+	contents, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(contents))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(contents) {
+		return fmt.Sprintf("<%d byte binary body redacted>", len(contents)), nil
+	}
+
+	redacted := sensitiveBodyFields.ReplaceAllString(string(contents), "${1}"+redactedValue+"${2}")
+	for _, tokenID := range identityTokenIDs(contents) {
+		quoted, _ := json.Marshal(tokenID)
+		redacted = strings.Replace(redacted, string(quoted), `"`+redactedValue+`"`, -1)
+	}
+	return redacted, nil
+}
+
+// identityTokenIDs returns the tokens issued by the identity service, found at access.token.id or token.id in a JSON body
+func identityTokenIDs(contents []byte) []string {
+	var identityResp struct {
+		Access struct {
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+		} `json:"access"`
+		Token struct {
+			ID string `json:"id"`
+		} `json:"token"`
+	}
+	// Fields of an unexpected type are skipped, so the error is ignored and whatever was decoded is used
+	json.Unmarshal(contents, &identityResp)
+
+	var tokenIDs []string
+	for _, tokenID := range []string{identityResp.Access.Token.ID, identityResp.Token.ID} {
+		if tokenID != "" {
+			tokenIDs = append(tokenIDs, tokenID)
+		}
+	}
+	return tokenIDs
+}
+
+func isBinaryContent(contentType string) bool {
+	for _, binaryType := range []string{"application/zip", "application/octet-stream", "application/x-gzip", "application/gzip"} {
+		if strings.HasPrefix(contentType, binaryType) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeaders(header http.Header) http.Header {
+	redacted := http.Header{}
+	for name, values := range header {
+		redacted[name] = values
+	}
+	for _, name := range sensitiveHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, redactedValue)
+		}
+	}
+	return redacted
+}
+
+// carinaRequestID reads the request ID from a Carina error response
+func carinaRequestID(body string) string {
+	var carinaResp CarinaGenericErrorResponse
+	if json.Unmarshal([]byte(body), &carinaResp) != nil || len(carinaResp.Errors) == 0 {
+		return ""
+	}
+	return carinaResp.Errors[0].RequestID
+}
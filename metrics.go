@@ -0,0 +1,58 @@
+package libcarina
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics receives measurements of the requests made by a CarinaClient, e.g. to export them to Prometheus.
+// Operations are the names of the CarinaClient methods, such as List or GetCredentials, and the requests made while
+// looking up a cluster are attributed to the operation which needed them. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveRequest records a request to the Carina API, statusCode is 0 when no response was received
+	ObserveRequest(operation string, statusCode int, duration time.Duration)
+
+	// IncRetry records a request being retried. CarinaClient does not retry on its own, this is for retrying middleware.
+	IncRetry(operation string)
+
+	// IncReauthentication records the cached token being rejected, so that the client authenticated again with the API key
+	IncReauthentication()
+
+	// IncClusterLookup records a request made only to resolve a cluster name or id, such as listing the clusters
+	IncClusterLookup(operation string)
+}
+
+// NopMetrics discards every measurement. Embed it to implement only some of the Metrics methods.
+type NopMetrics struct{}
+
+// ObserveRequest does nothing
+func (NopMetrics) ObserveRequest(operation string, statusCode int, duration time.Duration) {}
+
+// IncRetry does nothing
+func (NopMetrics) IncRetry(operation string) {}
+
+// IncReauthentication does nothing
+func (NopMetrics) IncReauthentication() {}
+
+// IncClusterLookup does nothing
+func (NopMetrics) IncClusterLookup(operation string) {}
+
+func (c *CarinaClient) metrics() Metrics {
+	if c.Metrics == nil {
+		return NopMetrics{}
+	}
+	return c.Metrics
+}
+
+type operationKey struct{}
+
+// withOperation records the name of the CarinaClient method making requests, e.g. List
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// OperationFromContext returns the name of the CarinaClient method which made a request, e.g. from the request context in middleware
+func OperationFromContext(ctx context.Context) string {
+	operation, _ := ctx.Value(operationKey{}).(string)
+	return operation
+}
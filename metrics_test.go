@@ -0,0 +1,84 @@
+package libcarina
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetrics records each measurement as a string, e.g. request GetCredentials 200
+type testMetrics struct {
+	mu           sync.Mutex
+	measurements []string
+}
+
+func (m *testMetrics) record(measurement string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.measurements = append(m.measurements, measurement)
+}
+
+func (m *testMetrics) ObserveRequest(operation string, statusCode int, duration time.Duration) {
+	m.record(fmt.Sprintf("request %s %d", operation, statusCode))
+}
+
+func (m *testMetrics) IncRetry(operation string) {
+	m.record("retry " + operation)
+}
+
+func (m *testMetrics) IncReauthentication() {
+	m.record("reauthentication")
+}
+
+func (m *testMetrics) IncClusterLookup(operation string) {
+	m.record("lookup " + operation)
+}
+
+func TestMetrics(t *testing.T) {
+	mockCarina, mockIdentity := createMockCarina(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/clusters":
+			fmt.Fprintf(w, `{"clusters": [{"id": "%s", "name": "mycluster"}]}`, storeTestClusterID)
+		case "/clusters/" + storeTestClusterID + "/credentials/zip":
+			w.Write(newTestZip(t, testZipEntry{"docker.env", swarmDockerEnv}))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer mockCarina.Close()
+	defer mockIdentity.Close()
+
+	metrics := &testMetrics{}
+	carinaClient, err := NewClientWithOptions(mockUsername, mockAPIKey, mockRegion, mockIdentity.URL+"/v2.0/", "expired-token", mockCarina.URL,
+		&ClientOpts{Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carinaClient.Endpoint = mockCarina.URL
+
+	if _, err = carinaClient.GetCredentials("mycluster"); err != nil {
+		t.Fatal(err)
+	}
+	carinaClient.Get(storeTestClusterID)
+
+	expected := []string{
+		"reauthentication",
+		"lookup GetCredentials",
+		"request GetCredentials 200",
+		"request GetCredentials 200",
+		"request Get 404",
+	}
+	if strings.Join(metrics.measurements, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, metrics.measurements)
+	}
+}
+
+func TestNopMetrics(t *testing.T) {
+	carinaClient := &CarinaClient{}
+	if _, ok := carinaClient.metrics().(NopMetrics); !ok {
+		t.Error("expected metrics to be discarded by default")
+	}
+}
@@ -0,0 +1,84 @@
+package libcarina
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader is the header used by RequestIDMiddleware to identify each request
+const RequestIDHeader = "X-Request-Id"
+
+// Doer sends an HTTP request and returns its response, *http.Client satisfies it
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DoerFunc adapts a function to the Doer interface
+type DoerFunc func(req *http.Request) (*http.Response, error)
+
+// Do calls f(req)
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps a Doer, e.g. to modify requests before calling next, or to observe the responses
+type Middleware func(next Doer) Doer
+
+// Use adds middleware to the requests made to the Carina API.
+// Middleware is called in the order it was added, so the first middleware sees the request first and the response last.
+func (c *CarinaClient) Use(middleware ...Middleware) {
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// doer chains the middleware around the HTTP client
+func (c *CarinaClient) doer() Doer {
+	var doer Doer = c.Client
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		doer = c.middleware[i](doer)
+	}
+	return doer
+}
+
+// LoggingMiddleware logs each request to the Carina API to logger, redacting secrets in the same way as ClientOpts.Logger.
+// It sees the request at its position in the middleware chain, e.g. with the header set by RequestIDMiddleware,
+// but unlike ClientOpts.Logger it does not see the requests made to the identity service.
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next Doer) Doer {
+		transport := &loggingTransport{next: doerTransport{next}, logger: logger}
+		return DoerFunc(transport.RoundTrip)
+	}
+}
+
+// doerTransport adapts a Doer to http.RoundTripper
+type doerTransport struct {
+	Doer
+}
+
+func (t doerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.Do(req)
+}
+
+// RequestIDMiddleware sets the RequestIDHeader on requests which don't already have one, so that a request can be
+// correlated across logs. newID generates the IDs, defaults to a random UUID.
+func RequestIDMiddleware(newID func() string) Middleware {
+	if newID == nil {
+		newID = newRequestID
+	}
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(RequestIDHeader) == "" {
+				req.Header.Set(RequestIDHeader, newID())
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
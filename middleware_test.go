@@ -0,0 +1,103 @@
+package libcarina
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestMiddlewareClient(t *testing.T, h handler) *CarinaClient {
+	mockCarina, mockIdentity := createMockCarina(h)
+	t.Cleanup(mockCarina.Close)
+	t.Cleanup(mockIdentity.Close)
+
+	carinaClient, err := createMockCarinaClient(mockIdentity.URL+"/v2.0/", mockCarina.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return carinaClient
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"clusters": [{"id": "%s"}]}`, r.Header.Get("X-Test"))
+	})
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name+" request")
+				req.Header.Set("X-Test", req.Header.Get("X-Test")+name)
+				resp, err := next.Do(req)
+				calls = append(calls, fmt.Sprintf("%s response %d", name, resp.StatusCode))
+				return resp, err
+			})
+		}
+	}
+	carinaClient.Use(record("a"), record("b"))
+
+	clusters, err := carinaClient.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusters[0].ID != "ab" {
+		t.Error("expected the middleware to modify the request, got", clusters[0].ID)
+	}
+	if strings.Join(calls, ",") != "a request,b request,b response 200,a response 200" {
+		t.Error("unexpected middleware order", calls)
+	}
+}
+
+func TestMiddlewareInjectsFaults(t *testing.T) {
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the request to be intercepted")
+	})
+	carinaClient.Use(func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				Status:     "503 Service Unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+				Request:    req,
+			}, nil
+		})
+	})
+
+	_, err := carinaClient.List()
+	if !isHTTPStatus(err, http.StatusServiceUnavailable) {
+		t.Error("expected the injected error, got", err)
+	}
+}
+
+func TestLoggingAndRequestIDMiddleware(t *testing.T) {
+	var requestID string
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(RequestIDHeader)
+		fmt.Fprintln(w, `{"cluster_types": []}`)
+	})
+
+	var buf bytes.Buffer
+	carinaClient.Use(RequestIDMiddleware(nil), LoggingMiddleware(NewStdLogger(log.New(&buf, "", 0), LogInfo)))
+
+	if _, err := carinaClient.ListClusterTypes(); err != nil {
+		t.Fatal(err)
+	}
+	if !isClusterID(requestID) {
+		t.Error("expected a generated request ID, got", requestID)
+	}
+
+	logged := buf.String()
+	expected := `level=info msg="carina request" api_version=`
+	if !strings.HasPrefix(logged, expected) || !strings.Contains(logged, "request_id="+requestID) ||
+		!strings.Contains(logged, "status=200") || !strings.Contains(logged, "url="+carinaClient.Endpoint+"/cluster_types") {
+		t.Errorf("expected %q with the request ID, got %q", expected, logged)
+	}
+	if strings.Contains(logged, carinaClient.Token) {
+		t.Error("expected the token to be redacted, got", logged)
+	}
+}
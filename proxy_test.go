@@ -0,0 +1,237 @@
+package libcarina
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestHTTPProxy starts a proxy which tunnels CONNECT requests and forwards plain HTTP requests, counting both
+func newTestHTTPProxy(t *testing.T) (*ProxyConfig, *int32) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if r.Method != "CONNECT" {
+			r.RequestURI = ""
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			for name, values := range resp.Header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipeConns(conn, buf, upstream)
+	}))
+	t.Cleanup(server.Close)
+
+	proxyConfig, err := ParseProxyURL(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proxyConfig, &count
+}
+
+// newTestSOCKS5Proxy starts a minimal SOCKS5 proxy, without authentication, which counts connections
+func newTestSOCKS5Proxy(t *testing.T) (*ProxyConfig, *int32) {
+	var count int32
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			go serveSOCKS5(conn)
+		}
+	}()
+
+	proxyConfig, err := ParseProxyURL("socks5://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proxyConfig, &count
+}
+
+func serveSOCKS5(conn net.Conn) {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		conn.Close()
+		return
+	}
+	io.CopyN(io.Discard, conn, int64(greeting[1]))
+	conn.Write([]byte{5, 0})
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		conn.Close()
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		size := make([]byte, 1)
+		io.ReadFull(conn, size)
+		name := make([]byte, size[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		conn.Close()
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipeConns(conn, conn, upstream)
+}
+
+func pipeConns(conn net.Conn, r io.Reader, upstream net.Conn) {
+	go func() {
+		io.Copy(upstream, r)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+func TestProxyForClusterConnections(t *testing.T) {
+	httpProxy, httpCount := newTestHTTPProxy(t)
+	socksProxy, socksCount := newTestSOCKS5Proxy(t)
+
+	testCases := []struct {
+		name  string
+		proxy *ProxyConfig
+		count *int32
+	}{
+		{"HTTP CONNECT", httpProxy, httpCount},
+		{"SOCKS5", socksProxy, socksCount},
+	}
+
+	for _, tc := range testCases {
+		_, creds := newTestCOEServer(t, func(w http.ResponseWriter, r *http.Request) {})
+		creds.Proxy = tc.proxy
+
+		if err := creds.Verify(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if atomic.LoadInt32(tc.count) != 1 {
+			t.Errorf("%s: expected Verify to connect through the proxy", tc.name)
+		}
+
+		client, err := creds.HTTPClient(&HTTPClientOpts{DisableKeepAlives: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := client.NewRequest("GET", "/info", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if atomic.LoadInt32(tc.count) != 2 {
+			t.Errorf("%s: expected the HTTP client to connect through the proxy", tc.name)
+		}
+	}
+}
+
+func TestProxyForCarinaClient(t *testing.T) {
+	proxyConfig, count := newTestHTTPProxy(t)
+	mockCarina, mockIdentity := createMockCarina(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"clusters":[]}`)
+	})
+	defer mockCarina.Close()
+	defer mockIdentity.Close()
+
+	carinaClient, err := NewClientWithOptions(mockUsername, mockAPIKey, mockRegion, mockIdentity.URL+"/v2.0/", "cached-token", mockCarina.URL, &ClientOpts{Proxy: proxyConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carinaClient.Endpoint = mockCarina.URL
+
+	// The cached token check fails, so the apikey is used to authenticate, and both go through the proxy
+	if n := atomic.LoadInt32(count); n != 2 {
+		t.Error("expected authentication to go through the proxy, requests:", n)
+	}
+
+	if _, err = carinaClient.List(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(count); n != 3 {
+		t.Error("expected API requests to go through the proxy, requests:", n)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, rawURL := range []string{"ftp://proxy:21", "http://", "%%"} {
+		if _, err := ParseProxyURL(rawURL); err == nil {
+			t.Error("expected an error for", rawURL)
+		}
+	}
+}
+
+func TestDirectProxyConfig(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	destination := mustParseURL(t, "https://api.dfw.getcarina.com")
+
+	proxyURL, err := (&ProxyConfig{Direct: true}).ProxyURL(destination)
+	if err != nil || proxyURL != nil {
+		t.Error("expected a direct connection, got", proxyURL, err)
+	}
+
+	var fromEnvironment *ProxyConfig
+	proxyURL, err = fromEnvironment.ProxyURL(destination)
+	if err != nil || proxyURL == nil || proxyURL.Host != "proxy.example.com:3128" {
+		t.Error("expected the proxy from the environment, got", proxyURL, err)
+	}
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
@@ -0,0 +1,137 @@
+package libcarina
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// The methods in this file use value receivers so that the redaction also applies
+// when a CredentialsBundle value, rather than a pointer, is printed or marshaled.
+
+// bundleFileSummary describes a file in a credentials bundle without revealing its contents
+type bundleFileSummary struct {
+	Name        string `json:"name"`
+	Size        int    `json:"size"`
+	Fingerprint string `json:"sha256_fingerprint,omitempty"`
+	PrivateKey  bool   `json:"private_key,omitempty"`
+}
+
+type bundleSummary struct {
+	ClusterName string              `json:"cluster_name,omitempty"`
+	COE         COE                 `json:"coe"`
+	Files       []bundleFileSummary `json:"files"`
+	Err         string              `json:"error,omitempty"`
+}
+
+// String describes the bundle with only file names, sizes and certificate fingerprints, private keys are never included
+func (creds CredentialsBundle) String() string {
+	summary := creds.summarize()
+
+	var files []string
+	for _, file := range summary.Files {
+		files = append(files, file.String())
+	}
+
+	s := fmt.Sprintf("CredentialsBundle{coe: %s, files: [%s]", summary.COE, strings.Join(files, ", "))
+	if summary.ClusterName != "" {
+		s += fmt.Sprintf(", cluster: %q", summary.ClusterName)
+	}
+	if summary.Err != "" {
+		s += fmt.Sprintf(", error: %q", summary.Err)
+	}
+	return s + "}"
+}
+
+// GoString describes the bundle for %#v, with the same redaction as String
+func (creds CredentialsBundle) GoString() string {
+	summary := creds.summarize()
+
+	var files []string
+	for _, file := range summary.Files {
+		files = append(files, fmt.Sprintf("%q: <%s>", file.Name, file.details()))
+	}
+
+	s := fmt.Sprintf("&libcarina.CredentialsBundle{Files: map[string][]byte{%s}", strings.Join(files, ", "))
+	if len(creds.RawZip) > 0 {
+		s += fmt.Sprintf(", RawZip: <redacted, %d bytes>", len(creds.RawZip))
+	}
+	if summary.Err != "" {
+		s += fmt.Sprintf(", Err: %q", summary.Err)
+	}
+	return s + "}"
+}
+
+// MarshalJSON describes the bundle with only file names, sizes and certificate fingerprints, private keys are never included.
+// Use MarshalSecretsJSON to intentionally export the file contents.
+func (creds CredentialsBundle) MarshalJSON() ([]byte, error) {
+	return json.Marshal(creds.summarize())
+}
+
+// MarshalSecretsJSON exports every file in the bundle, including private keys, as a JSON object of file names to contents.
+// The output is sensitive and must be protected accordingly.
+func (creds *CredentialsBundle) MarshalSecretsJSON() ([]byte, error) {
+	files := make(map[string]string, len(creds.Files))
+	for fileName, contents := range creds.Files {
+		files[fileName] = string(contents)
+	}
+	return json.Marshal(map[string]interface{}{"files": files})
+}
+
+func (creds CredentialsBundle) summarize() bundleSummary {
+	summary := bundleSummary{
+		ClusterName: creds.ClusterName(),
+		COE:         creds.COE(),
+		Files:       []bundleFileSummary{},
+	}
+	if creds.Err != nil {
+		summary.Err = creds.Err.Error()
+	}
+
+	for _, fileName := range creds.sortedFileNames() {
+		contents := creds.Files[fileName]
+		file := bundleFileSummary{
+			Name:       fileName,
+			Size:       len(contents),
+			PrivateKey: containsPrivateKey(contents),
+		}
+		if !file.PrivateKey {
+			if cert, err := parsePEMCertificate(fileName, contents); err == nil {
+				file.Fingerprint = CertificateFingerprint(cert)
+			}
+		}
+		summary.Files = append(summary.Files, file)
+	}
+
+	return summary
+}
+
+func (file bundleFileSummary) String() string {
+	return fmt.Sprintf("%s (%s)", file.Name, file.details())
+}
+
+func (file bundleFileSummary) details() string {
+	switch {
+	case file.PrivateKey:
+		return fmt.Sprintf("%d bytes, private key redacted", file.Size)
+	case file.Fingerprint != "":
+		return fmt.Sprintf("%d bytes, sha256 %s", file.Size, file.Fingerprint)
+	}
+	return fmt.Sprintf("%d bytes", file.Size)
+}
+
+// containsPrivateKey checks for a PEM encoded private key, e.g. RSA PRIVATE KEY or EC PRIVATE KEY
+func containsPrivateKey(contents []byte) bool {
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return bytes.Contains(contents, []byte("PRIVATE KEY"))
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return true
+		}
+	}
+}
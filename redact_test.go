@@ -0,0 +1,89 @@
+package libcarina
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsBundleRedaction(t *testing.T) {
+	creds := newTestCertifiedBundle(t, "tcp://172.99.65.11:2376")
+	creds.RawZip = []byte("raw zip contents")
+	key := string(creds.Files["key.pem"])
+
+	caCert, err := creds.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := CertificateFingerprint(caCert)
+
+	outputs := map[string]string{
+		"%v":  fmt.Sprintf("%v", creds),
+		"%+v": fmt.Sprintf("%+v", *creds),
+		"%#v": fmt.Sprintf("%#v", creds),
+		"%s":  fmt.Sprintf("%s", creds),
+	}
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs["json"] = string(data)
+
+	for format, output := range outputs {
+		if strings.Contains(output, "PRIVATE KEY") || strings.Contains(output, key) {
+			t.Errorf("%s leaked the private key: %s", format, output)
+		}
+		if strings.Contains(output, "raw zip contents") {
+			t.Errorf("%s leaked the raw zip: %s", format, output)
+		}
+		if !strings.Contains(output, "key.pem") {
+			t.Errorf("%s is missing the file names: %s", format, output)
+		}
+		if !strings.Contains(output, fingerprint) {
+			t.Errorf("%s is missing the CA fingerprint: %s", format, output)
+		}
+	}
+
+	var summary struct {
+		COE   string
+		Files []struct {
+			Name       string
+			Size       int
+			PrivateKey bool `json:"private_key"`
+		}
+	}
+	if err = json.Unmarshal(data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.COE != "swarm" || len(summary.Files) != 4 {
+		t.Error("unexpected summary", string(data))
+	}
+	for _, file := range summary.Files {
+		if file.Size != len(creds.Files[file.Name]) {
+			t.Error("unexpected size for", file.Name, file.Size)
+		}
+		if file.PrivateKey != (file.Name == "key.pem") {
+			t.Error("unexpected private key flag for", file.Name)
+		}
+	}
+}
+
+func TestMarshalSecretsJSON(t *testing.T) {
+	creds := newTestCertifiedBundle(t, "tcp://172.99.65.11:2376")
+
+	data, err := creds.MarshalSecretsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var secrets struct {
+		Files map[string]string
+	}
+	if err = json.Unmarshal(data, &secrets); err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Files["key.pem"] != string(creds.Files["key.pem"]) {
+		t.Error("expected the private key to be exported")
+	}
+}
@@ -0,0 +1,235 @@
+package libcarina
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clusterRef identifies a resolved cluster by both its id and name
+type clusterRef struct {
+	ID   string
+	Name string
+}
+
+// clusterIndex caches the clusters returned by List, so that several operations can resolve cluster names without listing them again
+type clusterIndex struct {
+	mu       sync.Mutex
+	clusters []*Cluster
+	expires  time.Time
+}
+
+func (index *clusterIndex) store(clusters []*Cluster, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	refs := make([]*Cluster, len(clusters))
+	for i, cluster := range clusters {
+		refs[i] = &Cluster{ID: cluster.ID, Name: cluster.Name}
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.clusters = refs
+	index.expires = time.Now().Add(ttl)
+}
+
+// load returns the cached clusters, with only their id and name, and false when the cache is empty or expired
+func (index *clusterIndex) load() ([]*Cluster, bool) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.clusters == nil || time.Now().After(index.expires) {
+		return nil, false
+	}
+	return index.clusters, true
+}
+
+func (index *clusterIndex) invalidate() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.clusters = nil
+}
+
+// InvalidateClusterIndex discards the cached cluster names and ids, see ClusterIndexTTL.
+// Create and Delete invalidate the index automatically.
+func (c *CarinaClient) InvalidateClusterIndex() {
+	c.index.invalidate()
+}
+
+// resolveCluster finds the id and name of a single cluster by its exact id or name, listing the clusters at most once.
+// Id prefixes and patterns are deliberately not resolved here, so that an operation such as Delete never acts on a cluster
+// the caller didn't name exactly, see FindCluster.
+// When token is an id the name is only looked up when needName is set, as most operations only need the id.
+func (c *CarinaClient) resolveCluster(ctx context.Context, token string, needName bool) (clusterRef, error) {
+	clusters, cached := c.index.load()
+
+	if isClusterID(token) {
+		ref := clusterRef{ID: strings.ToLower(token)}
+		if !needName {
+			return ref, nil
+		}
+		for _, cluster := range clusters {
+			if strings.EqualFold(cluster.ID, token) {
+				return clusterRef{ID: cluster.ID, Name: cluster.Name}, nil
+			}
+		}
+
+		c.metrics().IncClusterLookup(OperationFromContext(ctx))
+		cluster, err := c.getByID(ctx, ref.ID)
+		if err != nil {
+			return ref, err
+		}
+		return clusterRef{ID: cluster.ID, Name: cluster.Name}, nil
+	}
+
+	matches := clustersNamed(clusters, token)
+	// A name missing from the index may belong to a cluster created since it was cached, e.g. by another client,
+	// so the clusters are listed again before reporting that it doesn't exist
+	if !cached || len(matches) == 0 {
+		c.metrics().IncClusterLookup(OperationFromContext(ctx))
+		var err error
+		clusters, err = c.list(ctx)
+		if err != nil {
+			return clusterRef{}, err
+		}
+		matches = clustersNamed(clusters, token)
+	}
+
+	cluster, err := singleCluster(token, matches)
+	if err != nil {
+		return clusterRef{}, err
+	}
+	return clusterRef{ID: cluster.ID, Name: cluster.Name}, nil
+}
+
+// clustersNamed returns the clusters whose name is token, ignoring case
+func clustersNamed(clusters []*Cluster, token string) []*Cluster {
+	var matches []*Cluster
+	for _, cluster := range clusters {
+		if strings.EqualFold(cluster.Name, token) {
+			matches = append(matches, cluster)
+		}
+	}
+	return matches
+}
+
+// singleCluster returns the only match, or an error when there are none or several
+func singleCluster(token string, matches []*Cluster) (*Cluster, error) {
+	switch len(matches) {
+	case 0:
+		return nil, HTTPErr{
+			StatusCode: http.StatusNotFound,
+			Status:     "404 NOT FOUND",
+			Body:       `{"message": "Cluster "` + token + ` not found"}`}
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, AmbiguousClusterError{Token: token, Candidates: matches}
+	}
+}
+
+// FindCluster looks up a single cluster by its name, id, id prefix or glob pattern, see MatchClusters.
+// An AmbiguousClusterError listing the candidates is returned when more than one cluster matches.
+// Pass the id of the cluster found to operations such as Delete, which only accept an exact name or id.
+func (c *CarinaClient) FindCluster(token string) (*Cluster, error) {
+	return c.FindClusterContext(context.Background(), token)
+}
+
+// FindClusterContext is FindCluster with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) FindClusterContext(ctx context.Context, token string) (*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "FindCluster")
+	matches, err := c.findClusters(ctx, token)
+	if err != nil {
+		return nil, end(err)
+	}
+	cluster, err := singleCluster(token, matches)
+	return cluster, end(err)
+}
+
+// FindClusters lists the clusters identified by a name, id, id prefix or glob pattern, see MatchClusters.
+// Use it for operations on several clusters, an empty list is returned when nothing matches.
+func (c *CarinaClient) FindClusters(token string) ([]*Cluster, error) {
+	return c.FindClustersContext(context.Background(), token)
+}
+
+// FindClustersContext is FindClusters with a context, which is the parent of the operation's span and cancels its requests
+func (c *CarinaClient) FindClustersContext(ctx context.Context, token string) ([]*Cluster, error) {
+	ctx, end := c.startOperation(ctx, "FindClusters")
+	clusters, err := c.findClusters(ctx, token)
+	return clusters, end(err)
+}
+
+func (c *CarinaClient) findClusters(ctx context.Context, token string) ([]*Cluster, error) {
+	c.metrics().IncClusterLookup(OperationFromContext(ctx))
+	clusters, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return MatchClusters(clusters, token), nil
+}
+
+// MinClusterIDPrefixLength is the shortest id prefix which is matched against the cluster ids, like a short git commit hash
+const MinClusterIDPrefixLength = 4
+
+// MatchClusters returns the clusters identified by token, ignoring case, in the following order of precedence:
+//  1. The cluster whose id is token.
+//  2. The clusters named token.
+//  3. The clusters whose names match token as a glob pattern, e.g. ci-*, see path.Match,
+//     along with the clusters whose ids start with token when it is at least MinClusterIDPrefixLength characters.
+func MatchClusters(clusters []*Cluster, token string) []*Cluster {
+	var matches []*Cluster
+	for _, cluster := range clusters {
+		if strings.EqualFold(cluster.ID, token) {
+			return []*Cluster{cluster}
+		}
+		if strings.EqualFold(cluster.Name, token) {
+			matches = append(matches, cluster)
+		}
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+
+	pattern := strings.ToLower(token)
+	isPattern := strings.ContainsAny(token, "*?[")
+	isIDPrefix := len(token) >= MinClusterIDPrefixLength && clusterIDPrefixPattern.MatchString(token)
+	for _, cluster := range clusters {
+		if isPattern {
+			if ok, _ := path.Match(pattern, strings.ToLower(cluster.Name)); ok {
+				matches = append(matches, cluster)
+				continue
+			}
+		}
+		if isIDPrefix && strings.HasPrefix(strings.ToLower(cluster.ID), pattern) {
+			matches = append(matches, cluster)
+		}
+	}
+	return matches
+}
+
+var clusterIDPrefixPattern = regexp.MustCompile("^(?i)[a-f0-9][a-f0-9-]*$")
+
+// AmbiguousClusterError is returned when a cluster name, id prefix or pattern identifies more than one cluster
+// for an operation on a single cluster, such as Get or FindCluster
+type AmbiguousClusterError struct {
+	// Token is the name, id prefix or pattern used to identify the cluster
+	Token string
+
+	// Candidates are the clusters which matched the token
+	Candidates []*Cluster
+}
+
+// Error returns the error message, listing the candidates
+func (err AmbiguousClusterError) Error() string {
+	candidates := make([]string, len(err.Candidates))
+	for i, cluster := range err.Candidates {
+		candidates[i] = fmt.Sprintf("%s (%s)", cluster.Name, cluster.ID)
+	}
+	return fmt.Sprintf("The cluster (%s) is not unique. Retry the request using the cluster id, it matches: %s", err.Token, strings.Join(candidates, ", "))
+}
@@ -0,0 +1,212 @@
+package libcarina
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestResolveClient serves two clusters and records each request
+func newTestResolveClient(t *testing.T) (*CarinaClient, *[]string) {
+	var requests []string
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/clusters" && r.Method == "GET":
+			fmt.Fprintf(w, `{"clusters": [{"id": "%s", "name": "mycluster"}, {"id": "5d2ea2d4-3b1b-4a0e-9b49-2ef2a3a0e5b4", "name": "other"}]}`, storeTestClusterID)
+		case r.URL.Path == "/clusters/"+storeTestClusterID+"/credentials/zip":
+			w.Write(newTestZip(t, testZipEntry{"docker.env", swarmDockerEnv}))
+		case strings.HasPrefix(r.URL.Path, "/clusters"):
+			fmt.Fprintf(w, `{"id": "%s", "name": "mycluster"}`, storeTestClusterID)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return carinaClient, &requests
+}
+
+func TestResolveClusterOncePerOperation(t *testing.T) {
+	carinaClient, requests := newTestResolveClient(t)
+
+	creds, err := carinaClient.GetCredentials(storeTestClusterID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClusterName() != "mycluster" {
+		t.Error("expected the cluster name to be resolved from the id, got", creds.ClusterName())
+	}
+
+	if _, err = carinaClient.Resize("mycluster", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"GET /clusters/" + storeTestClusterID,
+		"GET /clusters/" + storeTestClusterID + "/credentials/zip",
+		"GET /clusters",
+		"POST /clusters/" + storeTestClusterID + "/tasks",
+		"GET /clusters/" + storeTestClusterID,
+	}
+	if strings.Join(*requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, *requests)
+	}
+}
+
+func TestClusterIndex(t *testing.T) {
+	carinaClient, requests := newTestResolveClient(t)
+	carinaClient.ClusterIndexTTL = time.Minute
+
+	for i := 0; i < 2; i++ {
+		if _, err := carinaClient.Get("mycluster"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := carinaClient.GetCredentials(storeTestClusterID); err != nil {
+		t.Fatal(err)
+	}
+	countLists := func() int {
+		lists := 0
+		for _, request := range *requests {
+			if request == "GET /clusters" {
+				lists++
+			}
+		}
+		return lists
+	}
+	if countLists() != 1 {
+		t.Error("expected the cluster index to be reused, got", *requests)
+	}
+
+	if _, err := carinaClient.Get("missing"); err == nil {
+		t.Error("expected an error for an unknown cluster")
+	}
+	if countLists() != 2 {
+		t.Error("expected the cluster index to be refreshed once before reporting an unknown cluster, got", *requests)
+	}
+
+	// The index predates a cluster created by another client
+	carinaClient.index.store([]*Cluster{{ID: storeTestClusterID, Name: "mycluster"}}, time.Minute)
+	if _, err := carinaClient.Get("other"); err != nil {
+		t.Error("expected a cluster missing from the index to be found by listing again, got", err)
+	}
+	if countLists() != 3 {
+		t.Error("expected the cluster index to be refreshed, got", *requests)
+	}
+	if _, err := carinaClient.Get("other"); err != nil || countLists() != 3 {
+		t.Error("expected the refreshed index to be reused, got", err, *requests)
+	}
+
+	if _, err := carinaClient.Create(&CreateClusterOpts{Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := carinaClient.index.load(); ok {
+		t.Error("expected Create to invalidate the cluster index")
+	}
+
+	carinaClient.List()
+	carinaClient.Delete("other")
+	if _, ok := carinaClient.index.load(); ok {
+		t.Error("expected Delete to invalidate the cluster index")
+	}
+}
+
+func TestMatchClusters(t *testing.T) {
+	clusters := []*Cluster{
+		{ID: "9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c", Name: "ci-1"},
+		{ID: "9f1a0b2c-1111-4c7c-91ef-e13ff94e352c", Name: "ci-2"},
+		{ID: "5d2ea2d4-3b1b-4a0e-9b49-2ef2a3a0e5b4", Name: "prod"},
+		{ID: "0d2ea2d4-3b1b-4a0e-9b49-2ef2a3a0e5b4", Name: "9f18"},
+	}
+
+	testcases := []struct {
+		token    string
+		expected string
+	}{
+		{"9F18F7F9-AEB4-4C7C-91EF-E13FF94E352C", "[ci-1]"},
+		{"PROD", "[prod]"},
+		{"9f18", "[9f18]"},
+		{"9f1", "[]"},
+		{"9f1a", "[ci-2]"},
+		{"9F1", "[]"},
+		{"5d2e", "[prod]"},
+		{"ci-*", "[ci-1 ci-2]"},
+		{"CI-?", "[ci-1 ci-2]"},
+		{"c[", "[]"},
+		{"missing", "[]"},
+	}
+	for _, tc := range testcases {
+		if names := clusterNames(MatchClusters(clusters, tc.token)); names != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.token, tc.expected, names)
+		}
+	}
+}
+
+func TestFindCluster(t *testing.T) {
+	carinaClient, _ := newTestResolveClient(t)
+
+	_, err := carinaClient.FindCluster("*")
+	ambiguous, ok := err.(AmbiguousClusterError)
+	if !ok {
+		t.Fatal("expected an AmbiguousClusterError, got", err)
+	}
+	if len(ambiguous.Candidates) != 2 || !strings.Contains(err.Error(), "mycluster ("+storeTestClusterID+"), other") {
+		t.Error("expected the candidates to be listed, got", err)
+	}
+
+	cluster, err := carinaClient.FindCluster("9F18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.ID != storeTestClusterID {
+		t.Error("expected the cluster to be resolved from the id prefix, got", cluster.ID)
+	}
+
+	if !isClusterID(strings.ToUpper(storeTestClusterID)) {
+		t.Error("expected an uppercase id to be recognized")
+	}
+}
+
+func TestResolveClusterIsExact(t *testing.T) {
+	carinaClient, requests := newTestResolveClient(t)
+
+	for _, token := range []string{"9f18", "5d2e", "other*", "*"} {
+		_, err := carinaClient.Delete(token)
+		if httpErr, ok := err.(HTTPErr); !ok || httpErr.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected a 404 error, got %v", token, err)
+		}
+	}
+	for _, request := range *requests {
+		if strings.HasPrefix(request, "DELETE") {
+			t.Error("expected only exact names and ids to be deleted, got", request)
+		}
+	}
+
+	if _, err := carinaClient.Get("MYCLUSTER"); err != nil {
+		t.Error("expected names to be matched ignoring case", err)
+	}
+	if _, err := carinaClient.Get(strings.ToUpper(storeTestClusterID)); err != nil {
+		t.Error("expected an uppercase id to be accepted", err)
+	}
+}
+
+func TestFindClusters(t *testing.T) {
+	carinaClient, _ := newTestResolveClient(t)
+
+	clusters, err := carinaClient.FindClusters("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusterNames(clusters) != "[mycluster other]" {
+		t.Error("expected every cluster to match, got", clusterNames(clusters))
+	}
+
+	clusters, err = carinaClient.FindClusters("ci-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 0 {
+		t.Error("expected no clusters to match, got", clusterNames(clusters))
+	}
+}
@@ -0,0 +1,193 @@
+package libcarina
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SealedCredentialsFileName is the name of the file holding an encrypted credentials bundle
+const SealedCredentialsFileName = "credentials.sealed"
+
+// CredentialsKeySize is the size, in bytes, of a caller-supplied key used to encrypt a credentials bundle
+const CredentialsKeySize = 32
+
+// sealedMagic identifies a sealed credentials bundle, it is followed by the format version
+var sealedMagic = []byte("CARINA-SEALED")
+
+const (
+	sealedVersion1 byte = 1
+
+	sealedKDFNone   byte = 0
+	sealedKDFScrypt byte = 1
+
+	scryptLogN    = 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// CredentialsKey encrypts a credentials bundle at rest.
+// Set either Passphrase, from which a key is derived with scrypt, or Key, which must be CredentialsKeySize bytes.
+type CredentialsKey struct {
+	Passphrase []byte
+	Key        []byte
+}
+
+// Seal encrypts every file in the credentials bundle with AES-256-GCM into a single versioned blob.
+//
+// The blob layout is: magic, version, KDF id, KDF parameters (scrypt log2(N), r, p and salt), nonce, ciphertext.
+// The header is authenticated along with the ciphertext.
+func (creds *CredentialsBundle) Seal(key *CredentialsKey) ([]byte, error) {
+	if key == nil {
+		return nil, errors.New("Unable to encrypt the credentials bundle. No key was provided")
+	}
+
+	header := &bytes.Buffer{}
+	header.Write(sealedMagic)
+	header.WriteByte(sealedVersion1)
+
+	var aesKey []byte
+	var err error
+	if key.Passphrase != nil {
+		salt := make([]byte, scryptSaltLen)
+		_, err = io.ReadFull(rand.Reader, salt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		header.Write([]byte{sealedKDFScrypt, scryptLogN, scryptR, scryptP})
+		header.Write(salt)
+		aesKey, err = scrypt.Key(key.Passphrase, salt, 1<<scryptLogN, scryptR, scryptP, CredentialsKeySize)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else {
+		header.WriteByte(sealedKDFNone)
+		aesKey = key.Key
+	}
+
+	gcm, err := newSealCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	header.Write(nonce)
+
+	plaintext := &bytes.Buffer{}
+	err = creds.WriteZip(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := header.Bytes()
+	return gcm.Seal(sealed, nonce, plaintext.Bytes(), sealed), nil
+}
+
+// IsSealed checks if data is an encrypted credentials bundle created by Seal
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// UnsealCredentialsBundle decrypts a credentials bundle created by Seal
+func UnsealCredentialsBundle(sealed []byte, key *CredentialsKey) (*CredentialsBundle, error) {
+	if !IsSealed(sealed) {
+		return nil, errors.New("Invalid credentials bundle. Not an encrypted credentials bundle")
+	}
+	if key == nil {
+		return nil, errors.New("Invalid credentials bundle. The credentials bundle is encrypted and no key was provided")
+	}
+
+	r := bytes.NewReader(sealed[len(sealedMagic):])
+	readBytes := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, errors.New("Invalid credentials bundle. The encrypted credentials bundle is truncated")
+		}
+		return b, nil
+	}
+
+	prefix, err := readBytes(2)
+	if err != nil {
+		return nil, err
+	}
+	version, kdf := prefix[0], prefix[1]
+	if version != sealedVersion1 {
+		return nil, fmt.Errorf("Invalid credentials bundle. Unsupported encryption format version %d", version)
+	}
+
+	var aesKey []byte
+	switch kdf {
+	case sealedKDFScrypt:
+		if key.Passphrase == nil {
+			return nil, errors.New("Invalid credentials bundle. The credentials bundle is encrypted with a passphrase")
+		}
+		params, err := readBytes(3 + scryptSaltLen)
+		if err != nil {
+			return nil, err
+		}
+		logN, r, p, salt := params[0], int(params[1]), int(params[2]), params[3:]
+		// Only accept the parameters used by Seal, so that a crafted header cannot demand an unbounded amount of memory
+		if logN > scryptLogN || r != scryptR || p != scryptP {
+			return nil, fmt.Errorf("Invalid credentials bundle. Unsupported scrypt parameters N=2^%d, r=%d, p=%d", logN, r, p)
+		}
+		aesKey, err = scrypt.Key(key.Passphrase, salt, 1<<logN, r, p, CredentialsKeySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid credentials bundle")
+		}
+	case sealedKDFNone:
+		if key.Key == nil {
+			return nil, errors.New("Invalid credentials bundle. The credentials bundle is encrypted with a key rather than a passphrase")
+		}
+		aesKey = key.Key
+	default:
+		return nil, fmt.Errorf("Invalid credentials bundle. Unsupported key derivation function %d", kdf)
+	}
+
+	gcm, err := newSealCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := readBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(sealed) - r.Len()
+	plaintext, err := gcm.Open(nil, nonce, sealed[headerLen:], sealed[:headerLen])
+	if err != nil {
+		return nil, errors.New("Invalid credentials bundle. Unable to decrypt, the key is incorrect or the file is corrupt")
+	}
+
+	return readCredentialsZip(bytes.NewReader(plaintext), &GetCredentialsOpts{
+		MaxTotalSize: int64(len(plaintext)),
+		UnknownFiles: UnknownFilesKeep,
+	})
+}
+
+func newSealCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != CredentialsKeySize {
+		return nil, fmt.Errorf("Invalid credentials key. Expected %d bytes, got %d", CredentialsKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return gcm, nil
+}
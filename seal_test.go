@@ -0,0 +1,164 @@
+package libcarina
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	creds := newTestSwarmBundle("mycluster")
+	creds.Files["key.pem"] = []byte("fake-key")
+
+	keys := []*CredentialsKey{
+		{Passphrase: []byte("correct horse battery staple")},
+		{Key: bytes.Repeat([]byte{7}, CredentialsKeySize)},
+	}
+	for _, key := range keys {
+		sealed, err := creds.Seal(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(sealed, []byte("fake-key")) {
+			t.Error("expected the key to be encrypted")
+		}
+
+		unsealed, err := UnsealCredentialsBundle(sealed, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unsealed.GetKey(), creds.GetKey()) || len(unsealed.Files) != len(creds.Files) {
+			t.Error("unexpected files after decrypting", unsealed.Files)
+		}
+	}
+}
+
+func TestUnsealRejectsWrongKeyAndTampering(t *testing.T) {
+	creds := newTestSwarmBundle("mycluster")
+	key := &CredentialsKey{Key: bytes.Repeat([]byte{7}, CredentialsKeySize)}
+	sealed, err := creds.Seal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = UnsealCredentialsBundle(sealed, &CredentialsKey{Key: bytes.Repeat([]byte{8}, CredentialsKeySize)}); err == nil {
+		t.Error("expected an error with the wrong key")
+	}
+	if _, err = UnsealCredentialsBundle(sealed, &CredentialsKey{Passphrase: []byte("guess")}); err == nil {
+		t.Error("expected an error with a passphrase instead of a key")
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(sealedMagic)+2] ^= 1 // first byte of the nonce
+	if _, err = UnsealCredentialsBundle(tampered, key); err == nil {
+		t.Error("expected an error when the header is modified")
+	}
+
+	tampered = append([]byte{}, sealed...)
+	tampered[len(sealedMagic)] = 99
+	if _, err = UnsealCredentialsBundle(tampered, key); err == nil {
+		t.Error("expected an error for an unsupported version")
+	}
+}
+
+func TestUnsealRejectsExpensiveScryptParameters(t *testing.T) {
+	creds := newTestSwarmBundle("mycluster")
+	key := &CredentialsKey{Passphrase: []byte("secret")}
+	sealed, err := creds.Seal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scrypt log2(N), r and p follow the magic, version and KDF id
+	params := len(sealedMagic) + 2
+	for i, value := range []byte{30, 255, 255} {
+		crafted := append([]byte{}, sealed...)
+		crafted[params+i] = value
+		if _, err = UnsealCredentialsBundle(crafted, key); err == nil || !strings.Contains(err.Error(), "Unsupported scrypt parameters") {
+			t.Errorf("expected parameter %d to be rejected before deriving the key, got %v", i, err)
+		}
+	}
+}
+
+func TestSaveAndLoadCredentialsBundle(t *testing.T) {
+	creds := newTestSwarmBundle("mycluster")
+	key := &CredentialsKey{Passphrase: []byte("secret")}
+
+	plainDir := filepath.Join(t.TempDir(), "plain")
+	if err := creds.Save(plainDir); err != nil {
+		t.Fatal(err)
+	}
+	loaded := LoadCredentialsBundle(plainDir)
+	if loaded.Err != nil || !bytes.Equal(loaded.GetCA(), creds.GetCA()) {
+		t.Error("unexpected plain bundle", loaded.Err)
+	}
+
+	sealedDir := filepath.Join(t.TempDir(), "sealed")
+	if err := creds.SaveSealed(sealedDir, key); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(sealedDir)
+	if len(files) != 1 || files[0].Name() != SealedCredentialsFileName {
+		t.Error("expected a single sealed file")
+	}
+	if fi, _ := os.Stat(filepath.Join(sealedDir, SealedCredentialsFileName)); fi.Mode().Perm() != 0600 {
+		t.Error("expected the sealed file to be private, got", fi.Mode())
+	}
+
+	loaded = LoadCredentialsBundleWithKey(sealedDir, key)
+	if loaded.Err != nil || !bytes.Equal(loaded.GetCA(), creds.GetCA()) {
+		t.Error("unexpected decrypted bundle", loaded.Err)
+	}
+	if loaded.ClusterName() != "mycluster" {
+		t.Error("unexpected cluster name", loaded.ClusterName())
+	}
+
+	if loaded = LoadCredentialsBundle(sealedDir); loaded.Err == nil {
+		t.Error("expected an error loading an encrypted bundle without a key")
+	}
+
+	loaded = LoadCredentialsBundleWithKey(filepath.Join(sealedDir, SealedCredentialsFileName), key)
+	if loaded.Err != nil || !bytes.Equal(loaded.GetKey(), creds.GetKey()) {
+		t.Error("unexpected bundle loaded from the sealed file", loaded.Err)
+	}
+	loaded = LoadCredentialsBundleWithKey(plainDir, key)
+	if loaded.Err != nil || !bytes.Equal(loaded.GetKey(), creds.GetKey()) {
+		t.Error("expected an unencrypted bundle to load with a key", loaded.Err)
+	}
+}
+
+func TestSaveReplacesOtherMode(t *testing.T) {
+	creds := newTestSwarmBundle("mycluster")
+	key := &CredentialsKey{Passphrase: []byte("secret")}
+	dir := t.TempDir()
+
+	if err := creds.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("mine"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := creds.SaveSealed(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 || files[0].Name() != SealedCredentialsFileName || files[1].Name() != "notes.txt" {
+		t.Error("expected the plaintext files to be removed when sealing, got", len(files), "files")
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(dir, "notes.txt")); string(contents) != "mine" {
+		t.Error("expected files which are not part of the bundle to be left alone")
+	}
+
+	if err := creds.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, SealedCredentialsFileName)); !os.IsNotExist(err) {
+		t.Error("expected the sealed file to be removed when saving unencrypted")
+	}
+	if loaded := LoadCredentialsBundle(dir); loaded.Err != nil || !bytes.Equal(loaded.GetKey(), creds.GetKey()) {
+		t.Error("unexpected plain bundle", loaded.Err)
+	}
+}
@@ -0,0 +1,287 @@
+package libcarina
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultServiceAccountNamespace is the namespace used when ServiceAccountOpts.Namespace is not set
+	DefaultServiceAccountNamespace = "default"
+
+	// DefaultServiceAccountTokenTimeout is how long to wait for Kubernetes to populate a service account token
+	DefaultServiceAccountTokenTimeout = 30 * time.Second
+
+	serviceAccountTokenType         = "kubernetes.io/service-account-token"
+	serviceAccountNameAnnotation    = "kubernetes.io/service-account.name"
+	serviceAccountTokenPollInterval = 500 * time.Millisecond
+)
+
+// ServiceAccountOpts defines the set of parameters when creating a kubeconfig for a service account
+type ServiceAccountOpts struct {
+	// Namespace of the service account, defaults to DefaultServiceAccountNamespace
+	Namespace string
+
+	// ClusterName is the name of the cluster in the kubeconfig, defaults to the name of the cluster in the credentials bundle
+	ClusterName string
+
+	// TokenTimeout limits how long to wait for the token to be populated, defaults to DefaultServiceAccountTokenTimeout
+	TokenTimeout time.Duration
+
+	// HTTPClientOpts customizes the connection to the Kubernetes API server
+	HTTPClientOpts *HTTPClientOpts
+}
+
+type kubeObjectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type kubeObjectReference struct {
+	Name string `json:"name"`
+}
+
+type kubeServiceAccount struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Metadata   kubeObjectMeta        `json:"metadata"`
+	Secrets    []kubeObjectReference `json:"secrets,omitempty"`
+}
+
+type kubeSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kubeObjectMeta    `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string][]byte `json:"data,omitempty"`
+}
+
+// ServiceAccountKubeConfig reads or creates a Kubernetes service account and its token secret,
+// returning a kubeconfig which authenticates as that service account instead of the cluster administrator
+func (creds *CredentialsBundle) ServiceAccountKubeConfig(account string, opts *ServiceAccountOpts) (*KubeConfig, error) {
+	if coe := creds.COE(); coe != KubernetesCOE {
+		return nil, fmt.Errorf("Unable to create a service account for a %s cluster", coe)
+	}
+	if account == "" {
+		return nil, errors.New("A service account name is required")
+	}
+	if opts == nil {
+		opts = &ServiceAccountOpts{}
+	}
+
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = DefaultServiceAccountNamespace
+	}
+	timeout := opts.TokenTimeout
+	if timeout <= 0 {
+		timeout = DefaultServiceAccountTokenTimeout
+	}
+
+	client, err := creds.HTTPClient(opts.HTTPClientOpts)
+	if err != nil {
+		return nil, err
+	}
+	api := &kubeAPI{client: client, namespace: namespace}
+
+	serviceAccount, err := api.getOrCreateServiceAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := api.findTokenSecret(serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		secret, err = api.createTokenSecret(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for len(secret.Data["token"]) == 0 {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for the token for service account %s/%s", namespace, account)
+		}
+		time.Sleep(serviceAccountTokenPollInterval)
+
+		secret, err = api.getSecret(secret.Metadata.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return creds.serviceAccountKubeConfig(account, namespace, opts.ClusterName, secret)
+}
+
+func (creds *CredentialsBundle) serviceAccountKubeConfig(account string, namespace string, clusterName string, secret *kubeSecret) (*KubeConfig, error) {
+	bundleCluster, _, _, err := creds.inlineKubeConfigEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := &KubeCluster{
+		Server:                   bundleCluster.Server,
+		CertificateAuthorityData: bundleCluster.CertificateAuthorityData,
+		InsecureSkipTLSVerify:    bundleCluster.InsecureSkipTLSVerify,
+	}
+	if ca := secret.Data["ca.crt"]; len(ca) > 0 {
+		cluster.CertificateAuthorityData = base64.StdEncoding.EncodeToString(ca)
+	}
+
+	if clusterName == "" {
+		clusterName = creds.ClusterName()
+	}
+	if clusterName == "" {
+		clusterName = "kubernetes"
+	}
+	contextName := account + "@" + clusterName
+
+	return &KubeConfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []*KubeNamedCluster{{Name: clusterName, Cluster: cluster}},
+		Users:          []*KubeNamedUser{{Name: account, User: &KubeUser{Token: string(secret.Data["token"])}}},
+		Contexts:       []*KubeNamedContext{{Name: contextName, Context: &KubeContext{Cluster: clusterName, User: account, Namespace: namespace}}},
+		CurrentContext: contextName,
+	}, nil
+}
+
+// kubeAPI makes the handful of Kubernetes API requests needed to manage service accounts
+type kubeAPI struct {
+	client    *ClusterHTTPClient
+	namespace string
+}
+
+func (api *kubeAPI) getOrCreateServiceAccount(name string) (*kubeServiceAccount, error) {
+	serviceAccount := &kubeServiceAccount{}
+	err := api.do("GET", "serviceaccounts/"+url.PathEscape(name), nil, serviceAccount)
+	if !isHTTPStatus(err, http.StatusNotFound) {
+		return serviceAccount, err
+	}
+
+	serviceAccount = &kubeServiceAccount{
+		APIVersion: "v1",
+		Kind:       "ServiceAccount",
+		Metadata:   kubeObjectMeta{Name: name, Namespace: api.namespace},
+	}
+	err = api.do("POST", "serviceaccounts", serviceAccount, serviceAccount)
+	if isHTTPStatus(err, http.StatusConflict) {
+		// Someone else created it first
+		err = api.do("GET", "serviceaccounts/"+url.PathEscape(name), nil, serviceAccount)
+	}
+	return serviceAccount, err
+}
+
+// findTokenSecret looks for a token secret associated with the service account, returning nil when there isn't one
+func (api *kubeAPI) findTokenSecret(serviceAccount *kubeServiceAccount) (*kubeSecret, error) {
+	// Kubernetes 1.24 and later no longer create token secrets automatically, so also check the name used by createTokenSecret
+	names := []string{}
+	for _, ref := range serviceAccount.Secrets {
+		names = append(names, ref.Name)
+	}
+	names = append(names, tokenSecretName(serviceAccount.Metadata.Name))
+
+	for _, name := range names {
+		secret, err := api.getSecret(name)
+		if isHTTPStatus(err, http.StatusNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if secret.Type == serviceAccountTokenType && secret.Metadata.Annotations[serviceAccountNameAnnotation] == serviceAccount.Metadata.Name {
+			return secret, nil
+		}
+	}
+	return nil, nil
+}
+
+func (api *kubeAPI) createTokenSecret(account string) (*kubeSecret, error) {
+	secret := &kubeSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubeObjectMeta{
+			Name:        tokenSecretName(account),
+			Namespace:   api.namespace,
+			Annotations: map[string]string{serviceAccountNameAnnotation: account},
+		},
+		Type: serviceAccountTokenType,
+	}
+	err := api.do("POST", "secrets", secret, secret)
+	if isHTTPStatus(err, http.StatusConflict) {
+		return api.getSecret(tokenSecretName(account))
+	}
+	return secret, err
+}
+
+func (api *kubeAPI) getSecret(name string) (*kubeSecret, error) {
+	secret := &kubeSecret{}
+	err := api.do("GET", "secrets/"+url.PathEscape(name), nil, secret)
+	return secret, err
+}
+
+// do sends a request for a resource in the namespace, decoding the response into result
+func (api *kubeAPI) do(method string, resource string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	uri := fmt.Sprintf("/api/v1/namespaces/%s/%s", url.PathEscape(api.namespace), resource)
+	req, err := api.client.NewRequest(method, uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if resp.StatusCode >= 400 {
+		return HTTPErr{
+			Method:     req.Method,
+			URL:        req.URL.String(),
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       string(b),
+		}
+	}
+
+	return errors.Wrapf(json.Unmarshal(b, result), "Unable to parse the response from %s %s", req.Method, req.URL)
+}
+
+func tokenSecretName(account string) string {
+	return account + "-token"
+}
+
+func isHTTPStatus(err error, statusCode int) bool {
+	httpErr, ok := errors.Cause(err).(HTTPErr)
+	return ok && httpErr.StatusCode == statusCode
+}
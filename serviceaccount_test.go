@@ -0,0 +1,184 @@
+package libcarina
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakeKubernetesAPI serves just enough of the Kubernetes API to manage service accounts and their token secrets
+type fakeKubernetesAPI struct {
+	mu              sync.Mutex
+	serviceAccounts map[string]*kubeServiceAccount
+	secrets         map[string]*kubeSecret
+	requests        []string
+	forbidden       bool
+}
+
+func newTestKubernetesAPI(t *testing.T) (*fakeKubernetesAPI, *CredentialsBundle) {
+	api := &fakeKubernetesAPI{
+		serviceAccounts: map[string]*kubeServiceAccount{},
+		secrets:         map[string]*kubeSecret{},
+	}
+
+	server, creds := newTestCOEServer(t, api.ServeHTTP)
+	delete(creds.Files, "docker.env")
+	creds.Files["kubectl.config"] = []byte(strings.Replace(kubernetesKubeConfig, "https://172.99.79.252:6443", server.URL, 1))
+	creds.Files["kubectl.env"] = []byte("export KUBECONFIG=$(pwd)/kubectl.config\nexport CARINA_CLUSTER_NAME=mycluster\n")
+	return api, creds
+}
+
+func (api *fakeKubernetesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.requests = append(api.requests, r.Method+" "+r.URL.Path)
+	if api.forbidden {
+		http.Error(w, `{"kind": "Status", "reason": "Forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	key := parts[0] + "/"
+	if len(parts) > 2 {
+		key += parts[2]
+	}
+
+	switch {
+	case parts[1] == "serviceaccounts" && r.Method == "GET":
+		api.reply(w, api.serviceAccounts[key])
+	case parts[1] == "serviceaccounts" && r.Method == "POST":
+		serviceAccount := &kubeServiceAccount{}
+		json.NewDecoder(r.Body).Decode(serviceAccount)
+		api.serviceAccounts[key+serviceAccount.Metadata.Name] = serviceAccount
+		api.reply(w, serviceAccount)
+	case parts[1] == "secrets" && r.Method == "GET":
+		secret := api.secrets[key]
+		if secret != nil && secret.Data == nil {
+			// Simulate the token controller populating the secret after it is created
+			secret.Data = map[string][]byte{"token": []byte("token-for-" + secret.Metadata.Annotations[serviceAccountNameAnnotation]), "ca.crt": []byte("fake-ca")}
+			api.reply(w, &kubeSecret{Metadata: secret.Metadata, Type: secret.Type})
+			return
+		}
+		api.reply(w, secret)
+	case parts[1] == "secrets" && r.Method == "POST":
+		secret := &kubeSecret{}
+		json.NewDecoder(r.Body).Decode(secret)
+		api.secrets[key+secret.Metadata.Name] = secret
+		api.reply(w, secret)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *fakeKubernetesAPI) reply(w http.ResponseWriter, obj interface{}) {
+	switch v := obj.(type) {
+	case *kubeServiceAccount:
+		if v == nil {
+			http.Error(w, `{"kind": "Status", "reason": "NotFound"}`, http.StatusNotFound)
+			return
+		}
+	case *kubeSecret:
+		if v == nil {
+			http.Error(w, `{"kind": "Status", "reason": "NotFound"}`, http.StatusNotFound)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(obj)
+}
+
+func TestServiceAccountKubeConfig(t *testing.T) {
+	api, creds := newTestKubernetesAPI(t)
+
+	kubeConfig, err := creds.ServiceAccountKubeConfig("ci", &ServiceAccountOpts{Namespace: "builds"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if api.serviceAccounts["builds/ci"] == nil {
+		t.Error("expected the service account to be created")
+	}
+	secret := api.secrets["builds/ci-token"]
+	if secret == nil || secret.Type != serviceAccountTokenType {
+		t.Fatal("expected a token secret to be created", secret)
+	}
+
+	if kubeConfig.CurrentContext != "ci@mycluster" {
+		t.Error("unexpected current context", kubeConfig.CurrentContext)
+	}
+	context := kubeConfig.FindContext("ci@mycluster")
+	if context == nil || context.Namespace != "builds" || context.User != "ci" {
+		t.Fatal("unexpected context", context)
+	}
+	user := kubeConfig.FindUser("ci")
+	if user == nil || user.Token != "token-for-ci" || user.ClientKeyData != "" {
+		t.Error("expected only the service account token", user)
+	}
+	cluster, err := kubeConfig.CurrentCluster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.CertificateAuthorityData != "ZmFrZS1jYQ==" {
+		t.Error("expected the CA from the token secret", cluster.CertificateAuthorityData)
+	}
+	if baseURL, _ := creds.BaseURL(); cluster.Server != baseURL.String() {
+		t.Error("unexpected server", cluster.Server)
+	}
+}
+
+func TestServiceAccountKubeConfigExistingAccount(t *testing.T) {
+	api, creds := newTestKubernetesAPI(t)
+	api.serviceAccounts["default/deployer"] = &kubeServiceAccount{
+		Metadata: kubeObjectMeta{Name: "deployer", Namespace: "default"},
+		Secrets:  []kubeObjectReference{{Name: "deployer-token-x7k2p"}},
+	}
+	api.secrets["default/deployer-token-x7k2p"] = &kubeSecret{
+		Metadata: kubeObjectMeta{Name: "deployer-token-x7k2p", Annotations: map[string]string{serviceAccountNameAnnotation: "deployer"}},
+		Type:     serviceAccountTokenType,
+		Data:     map[string][]byte{"token": []byte("existing-token")},
+	}
+
+	kubeConfig, err := creds.ServiceAccountKubeConfig("deployer", &ServiceAccountOpts{ClusterName: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user := kubeConfig.FindUser("deployer"); user == nil || user.Token != "existing-token" {
+		t.Error("expected the existing token", user)
+	}
+	if kubeConfig.FindCluster("prod") == nil {
+		t.Error("expected the cluster to be named prod")
+	}
+	for _, request := range api.requests {
+		if strings.HasPrefix(request, "POST") {
+			t.Error("expected nothing to be created, got", request)
+		}
+	}
+}
+
+func TestServiceAccountKubeConfigErrors(t *testing.T) {
+	_, creds := newTestCOEServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	if _, err := creds.ServiceAccountKubeConfig("ci", nil); err == nil {
+		t.Error("expected an error for a swarm cluster")
+	}
+
+	api, creds := newTestKubernetesAPI(t)
+	if _, err := creds.ServiceAccountKubeConfig("", nil); err == nil {
+		t.Error("expected an error without a service account name")
+	}
+
+	api.forbidden = true
+	_, err := creds.ServiceAccountKubeConfig("ci", nil)
+	if httpErr, ok := errors.Cause(err).(HTTPErr); !ok || httpErr.StatusCode != http.StatusForbidden {
+		t.Error("expected a forbidden error, got", err)
+	}
+}
@@ -0,0 +1,320 @@
+package libcarina
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var shellVariableName = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// ParseError describes a malformed file in a credentials bundle
+type ParseError struct {
+	// File is the name of the malformed file, e.g. docker.env
+	File string
+
+	// Line is the 1-based line number where the problem was found, or 0 if unknown
+	Line int
+
+	// Message describes the problem
+	Message string
+}
+
+// Error formats the parse error as file:line: message
+func (err ParseError) Error() string {
+	switch {
+	case err.File == "":
+		return fmt.Sprintf("line %d: %s", err.Line, err.Message)
+	case err.Line == 0:
+		return fmt.Sprintf("%s: %s", err.File, err.Message)
+	default:
+		return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Message)
+	}
+}
+
+// shellEnvParser reads variable assignments from a POSIX shell script, such as docker.env.
+// Only assignments, optionally prefixed with export, and comments are accepted.
+// Variable references and command substitutions are preserved as-is rather than expanded.
+type shellEnvParser struct {
+	file   string
+	script []byte
+	pos    int
+	line   int
+	env    map[string]string
+}
+
+// parseShellEnv returns the variables assigned in a shell script
+func parseShellEnv(file string, script []byte) (map[string]string, error) {
+	p := &shellEnvParser{
+		file:   file,
+		script: script,
+		line:   1,
+		env:    make(map[string]string),
+	}
+
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return p.env, nil
+		}
+		if err := p.parseStatement(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *shellEnvParser) errorf(line int, format string, args ...interface{}) error {
+	return ParseError{File: p.file, Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *shellEnvParser) eof() bool {
+	return p.pos >= len(p.script)
+}
+
+func (p *shellEnvParser) peek() byte {
+	return p.script[p.pos]
+}
+
+func (p *shellEnvParser) next() byte {
+	c := p.script[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// skipBlank advances past whitespace, statement separators, comments and line continuations
+func (p *shellEnvParser) skipBlank() {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';':
+			p.next()
+		case c == '#':
+			p.skipComment()
+		case c == '\\' && p.pos+1 < len(p.script) && p.script[p.pos+1] == '\n':
+			p.next()
+			p.next()
+		default:
+			return
+		}
+	}
+}
+
+// skipSpace advances past whitespace within a single statement
+func (p *shellEnvParser) skipSpace() {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.next()
+		case c == '\\' && p.pos+1 < len(p.script) && p.script[p.pos+1] == '\n':
+			p.next()
+			p.next()
+		default:
+			return
+		}
+	}
+}
+
+func (p *shellEnvParser) skipComment() {
+	for !p.eof() && p.peek() != '\n' {
+		p.next()
+	}
+}
+
+func (p *shellEnvParser) endOfStatement() bool {
+	if p.eof() {
+		return true
+	}
+	switch p.peek() {
+	case '\n', ';', '#':
+		return true
+	}
+	return false
+}
+
+func (p *shellEnvParser) parseStatement() error {
+	line := p.line
+	word, err := p.parseWord()
+	if err != nil {
+		return err
+	}
+
+	isExport := word == "export"
+	if isExport {
+		p.skipSpace()
+		if p.endOfStatement() {
+			return p.errorf(line, "expected a variable after export")
+		}
+		word, err = p.parseWord()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		name, value, isAssignment := splitAssignment(word)
+		if !shellVariableName.MatchString(name) {
+			if isAssignment {
+				return p.errorf(line, "invalid variable name %q", name)
+			}
+			return p.errorf(line, "expected a variable assignment, found %q", word)
+		}
+		if isAssignment {
+			p.env[name] = value
+		} else if !isExport {
+			return p.errorf(line, "expected a variable assignment, found %q", word)
+		}
+
+		p.skipSpace()
+		if p.endOfStatement() {
+			return nil
+		}
+
+		line = p.line
+		word, err = p.parseWord()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitAssignment splits NAME=value into its parts.
+// The value has already had its quotes removed by parseWord, so only the first = matters.
+func splitAssignment(word string) (string, string, bool) {
+	i := strings.IndexByte(word, '=')
+	if i < 0 {
+		return word, "", false
+	}
+	return word[:i], word[i+1:], true
+}
+
+// parseWord reads a single shell word, removing quotes and backslash escapes
+func (p *shellEnvParser) parseWord() (string, error) {
+	var word bytes.Buffer
+	for !p.eof() {
+		c := p.peek()
+		switch c {
+		case ' ', '\t', '\r', '\n', ';':
+			return word.String(), nil
+		case '|', '&', '<', '>', '(', ')', '`':
+			return "", p.errorf(p.line, "unsupported shell syntax %q", string(c))
+		case '\'':
+			start := p.line
+			p.next()
+			for {
+				if p.eof() {
+					return "", p.errorf(start, "unterminated single-quoted string")
+				}
+				c = p.next()
+				if c == '\'' {
+					break
+				}
+				word.WriteByte(c)
+			}
+		case '"':
+			if err := p.parseDoubleQuoted(&word); err != nil {
+				return "", err
+			}
+		case '\\':
+			p.next()
+			if p.eof() {
+				return "", p.errorf(p.line, "unexpected end of file after \\")
+			}
+			if c = p.next(); c != '\n' {
+				word.WriteByte(c)
+			}
+		case '$':
+			if err := p.parseDollar(&word); err != nil {
+				return "", err
+			}
+		default:
+			word.WriteByte(p.next())
+		}
+	}
+	return word.String(), nil
+}
+
+func (p *shellEnvParser) parseDoubleQuoted(word *bytes.Buffer) error {
+	start := p.line
+	p.next()
+	for {
+		if p.eof() {
+			return p.errorf(start, "unterminated double-quoted string")
+		}
+		switch c := p.peek(); c {
+		case '"':
+			p.next()
+			return nil
+		case '\\':
+			p.next()
+			if p.eof() {
+				return p.errorf(start, "unterminated double-quoted string")
+			}
+			c = p.next()
+			switch c {
+			case '$', '`', '"', '\\':
+				word.WriteByte(c)
+			case '\n':
+			default:
+				word.WriteByte('\\')
+				word.WriteByte(c)
+			}
+		case '$':
+			if err := p.parseDollar(word); err != nil {
+				return err
+			}
+		default:
+			word.WriteByte(p.next())
+		}
+	}
+}
+
+// parseDollar copies a variable reference or command substitution, e.g. $(pwd), through unexpanded
+func (p *shellEnvParser) parseDollar(word *bytes.Buffer) error {
+	start := p.line
+	word.WriteByte(p.next())
+	if p.eof() {
+		return nil
+	}
+
+	var open, close byte
+	switch p.peek() {
+	case '(':
+		open, close = '(', ')'
+	case '{':
+		open, close = '{', '}'
+	default:
+		return nil
+	}
+
+	depth := 0
+	var quote byte
+	for {
+		if p.eof() {
+			return p.errorf(start, "unterminated $%c", open)
+		}
+		c := p.next()
+		word.WriteByte(c)
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' && !p.eof() {
+				word.WriteByte(p.next())
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '\\' && !p.eof():
+			word.WriteByte(p.next())
+		case c == open:
+			depth++
+		case c == close:
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
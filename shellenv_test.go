@@ -0,0 +1,67 @@
+package libcarina
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseShellEnv(t *testing.T) {
+	script := `#!/usr/bin/env bash
+# DOCKER_HOST=tcp://commented-out:2376
+export DOCKER_HOST="tcp://172.99.65.11:2376" # trailing comment
+DOCKER_TLS_VERIFY=1; export DOCKER_TLS_VERIFY
+export DOCKER_CERT_PATH=$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)
+export A='it'\''s' B="say \"hi\"" C=a#b
+export D=multi\
+line
+`
+	env, err := parseShellEnv("docker.env", []byte(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"DOCKER_HOST":       "tcp://172.99.65.11:2376",
+		"DOCKER_TLS_VERIFY": "1",
+		"DOCKER_CERT_PATH":  `$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)`,
+		"A":                 "it's",
+		"B":                 `say "hi"`,
+		"C":                 "a#b",
+		"D":                 "multiline",
+	}
+	if len(env) != len(expected) {
+		t.Errorf("expected %d variables, got %d: %v", len(expected), len(env), env)
+	}
+	for name, value := range expected {
+		if env[name] != value {
+			t.Errorf("expected %s=%s, got %s", name, value, env[name])
+		}
+	}
+}
+
+func TestParseShellEnvErrors(t *testing.T) {
+	testCases := []struct {
+		script string
+		line   int
+	}{
+		{"export A=1\nexport B='unterminated\n", 2},
+		{"\n\nexport C=\"unterminated\n\n", 3},
+		{"A=1\necho hello\n", 2},
+		{"export 1A=bad\n", 1},
+		{"A=1 | cat\n", 1},
+		{"export\n", 1},
+	}
+
+	for _, tc := range testCases {
+		_, err := parseShellEnv("docker.env", []byte(tc.script))
+		parseErr, ok := errors.Cause(err).(ParseError)
+		if !ok {
+			t.Errorf("expected a ParseError for %q, got %v", tc.script, err)
+			continue
+		}
+		if parseErr.File != "docker.env" || parseErr.Line != tc.line {
+			t.Errorf("expected an error at docker.env:%d for %q, got %s", tc.line, tc.script, parseErr)
+		}
+	}
+}
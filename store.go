@@ -0,0 +1,260 @@
+package libcarina
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCredentialsRenewBefore is how long before the client certificate expires that a cached bundle is refreshed
+const DefaultCredentialsRenewBefore = 24 * time.Hour
+
+// CredentialsStore caches credentials bundles on the filesystem, keyed by cluster ID, so that they are only downloaded when necessary
+type CredentialsStore struct {
+	// Client is used to check that clusters still exist, and to download credentials
+	Client *CarinaClient
+
+	// Root is the directory holding the cached bundles, one sub-directory per cluster ID
+	Root string
+
+	// Key optionally encrypts the cached bundles, see CredentialsBundle.SaveSealed
+	Key *CredentialsKey
+
+	// RenewBefore is how long before the client certificate expires that the bundle is refreshed, defaults to DefaultCredentialsRenewBefore
+	RenewBefore time.Duration
+}
+
+// CachedCredentials describes a credentials bundle held in a CredentialsStore
+type CachedCredentials struct {
+	// ClusterID is the id of the cluster
+	ClusterID string
+
+	// ClusterName is the name of the cluster when the bundle was downloaded
+	ClusterName string
+
+	// Path is the directory holding the bundle
+	Path string
+
+	// SavedAt is when the bundle was downloaded
+	SavedAt time.Time
+
+	// NotAfter is when the client certificate expires
+	NotAfter time.Time
+
+	// Fresh indicates that the client certificate is valid and is not due to be renewed
+	Fresh bool
+
+	// Err is set when the bundle could not be read, only ClusterID, Path and SavedAt are populated
+	Err error
+}
+
+// NewCredentialsStore creates a CredentialsStore which caches bundles under root
+func NewCredentialsStore(client *CarinaClient, root string) *CredentialsStore {
+	return &CredentialsStore{
+		Client: client,
+		Root:   root,
+	}
+}
+
+// Get returns the credentials for the given cluster name or id.
+// The cached bundle is returned if the cluster still exists and its certificates are valid, otherwise the bundle is downloaded again.
+// The cached bundle is removed when the cluster no longer exists.
+func (s *CredentialsStore) Get(token string) (*CredentialsBundle, error) {
+	cluster, err := s.lookupCluster(token)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := LoadCredentialsBundleWithKey(s.path(cluster.ID), s.Key)
+	if creds.Err == nil && s.isFresh(creds) {
+		creds.Proxy = s.Client.Proxy
+		return creds, nil
+	}
+
+	return s.download(cluster.ID)
+}
+
+// Refresh downloads the credentials for the given cluster name or id, replacing any cached bundle
+func (s *CredentialsStore) Refresh(token string) (*CredentialsBundle, error) {
+	cluster, err := s.lookupCluster(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.download(cluster.ID)
+}
+
+// Remove deletes the cached bundle for a cluster id. It is not an error if the bundle is not cached.
+func (s *CredentialsStore) Remove(id string) error {
+	if !isClusterID(id) {
+		return fmt.Errorf("Unable to remove the cached credentials. Invalid cluster id %q", id)
+	}
+	err := os.RemoveAll(s.path(id))
+	return errors.Wrapf(err, "Unable to remove the cached credentials for %s", id)
+}
+
+// Prune removes the cached bundles for clusters which no longer exist, returning the ids of the removed bundles
+func (s *CredentialsStore) Prune() ([]string, error) {
+	ids, err := s.cachedIDs()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	clusters, err := s.Client.List()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		exists[strings.ToLower(cluster.ID)] = true
+	}
+
+	var removed []string
+	for _, id := range ids {
+		if exists[strings.ToLower(id)] {
+			continue
+		}
+		err = s.Remove(id)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, id)
+	}
+
+	return removed, nil
+}
+
+// List describes the cached bundles, without contacting Carina.
+// A bundle which cannot be read is still listed, with its Err set, so that one bad entry doesn't hide the others.
+func (s *CredentialsStore) List() ([]*CachedCredentials, error) {
+	ids, err := s.cachedIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var cached []*CachedCredentials
+	for _, id := range ids {
+		bundlePath := s.path(id)
+		entry := &CachedCredentials{
+			ClusterID: id,
+			Path:      bundlePath,
+		}
+		if fi, err := os.Stat(bundlePath); err == nil {
+			entry.SavedAt = fi.ModTime()
+		}
+		cached = append(cached, entry)
+
+		creds := LoadCredentialsBundleWithKey(bundlePath, s.Key)
+		if creds.Err != nil {
+			entry.Err = creds.Err
+			continue
+		}
+
+		entry.ClusterName = creds.ClusterName()
+		entry.Fresh = s.isFresh(creds)
+		if cert, err := creds.ClientCertificate(); err == nil {
+			entry.NotAfter = cert.NotAfter
+		}
+	}
+
+	return cached, nil
+}
+
+func (s *CredentialsStore) lookupCluster(token string) (*Cluster, error) {
+	cluster, err := s.Client.Get(token)
+	if err != nil {
+		if httpErr, ok := errors.Cause(err).(HTTPErr); ok && httpErr.StatusCode == http.StatusNotFound && isClusterID(token) {
+			s.Remove(token)
+		}
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// download fetches the credentials and replaces the cached bundle.
+// The bundle is saved to a temporary directory first so that a failure never leaves a partial bundle behind.
+func (s *CredentialsStore) download(id string) (*CredentialsBundle, error) {
+	creds, err := s.Client.GetCredentials(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(s.Root, 0700)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to create %s", s.Root)
+	}
+
+	tmp, err := ioutil.TempDir(s.Root, ".download-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to create a temporary directory in %s", s.Root)
+	}
+	defer os.RemoveAll(tmp)
+
+	if s.Key != nil {
+		err = creds.SaveSealed(tmp, s.Key)
+	} else {
+		err = creds.Save(tmp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Remove(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp, s.path(id))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to save the credentials for %s", id)
+	}
+
+	return creds, nil
+}
+
+// isFresh checks that the client certificate is currently valid and is not due to be renewed
+func (s *CredentialsStore) isFresh(creds *CredentialsBundle) bool {
+	cert, err := creds.ClientCertificate()
+	if err != nil {
+		return false
+	}
+
+	renewBefore := s.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = DefaultCredentialsRenewBefore
+	}
+
+	now := time.Now()
+	return !now.Before(cert.NotBefore) && now.Add(renewBefore).Before(cert.NotAfter)
+}
+
+func (s *CredentialsStore) cachedIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(s.Root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to list the cached credentials in %s", s.Root)
+	}
+
+	var ids []string
+	for _, file := range files {
+		if file.IsDir() && isClusterID(file.Name()) {
+			ids = append(ids, file.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *CredentialsStore) path(id string) string {
+	return filepath.Join(s.Root, strings.ToLower(id))
+}
@@ -0,0 +1,182 @@
+package libcarina
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const storeTestClusterID = "9f18f7f9-aeb4-4c7c-91ef-e13ff94e352c"
+
+// mockCredentialsCarina serves a single cluster and counts credential downloads
+type mockCredentialsCarina struct {
+	t         *testing.T
+	mu        sync.Mutex
+	clusters  []*Cluster
+	notAfter  time.Time
+	downloads int
+}
+
+func (m *mockCredentialsCarina) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/clusters" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"clusters": m.clusters})
+		return
+	}
+
+	for _, cluster := range m.clusters {
+		switch r.URL.Path {
+		case "/clusters/" + cluster.ID:
+			json.NewEncoder(w).Encode(cluster)
+			return
+		case "/clusters/" + cluster.ID + "/credentials/zip":
+			m.downloads++
+			ca, cert, key := newTestCertificates(m.t, time.Now().Add(-time.Hour), m.notAfter)
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write(newTestZip(m.t,
+				testZipEntry{"docker.env", swarmDockerEnv},
+				testZipEntry{"ca.pem", string(ca)},
+				testZipEntry{"cert.pem", string(cert)},
+				testZipEntry{"key.pem", string(key)}))
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintln(w, `{"errors":[{"title":"Not Found"}]}`)
+}
+
+func newTestCredentialsStore(t *testing.T) (*CredentialsStore, *mockCredentialsCarina) {
+	mock := &mockCredentialsCarina{
+		t:        t,
+		clusters: []*Cluster{{ID: storeTestClusterID, Name: "mycluster", Status: "active"}},
+		notAfter: time.Now().Add(30 * 24 * time.Hour),
+	}
+	mockCarina := httptest.NewServer(mock)
+	mockIdentity := httptest.NewServer(http.HandlerFunc(identityHandler))
+	t.Cleanup(mockCarina.Close)
+	t.Cleanup(mockIdentity.Close)
+
+	carinaClient, err := createMockCarinaClient(mockIdentity.URL+"/v2.0/", mockCarina.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewCredentialsStore(carinaClient, t.TempDir()), mock
+}
+
+func TestCredentialsStoreCachesBundles(t *testing.T) {
+	store, mock := newTestCredentialsStore(t)
+
+	for i := 0; i < 2; i++ {
+		creds, err := store.Get("mycluster")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.ClusterName() != "mycluster" {
+			t.Error("unexpected cluster name", creds.ClusterName())
+		}
+	}
+	if mock.downloads != 1 {
+		t.Error("expected the cached bundle to be reused, downloads:", mock.downloads)
+	}
+
+	cached, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 1 || cached[0].ClusterID != storeTestClusterID || cached[0].ClusterName != "mycluster" || !cached[0].Fresh {
+		t.Errorf("unexpected cache listing %+v", cached)
+	}
+
+	if _, err = store.Refresh(storeTestClusterID); err != nil {
+		t.Fatal(err)
+	}
+	if mock.downloads != 2 {
+		t.Error("expected Refresh to download the bundle, downloads:", mock.downloads)
+	}
+}
+
+func TestCredentialsStoreRefreshesExpiringBundles(t *testing.T) {
+	store, mock := newTestCredentialsStore(t)
+	mock.notAfter = time.Now().Add(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get(storeTestClusterID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mock.downloads != 2 {
+		t.Error("expected a bundle expiring within RenewBefore to be downloaded again, downloads:", mock.downloads)
+	}
+
+	store.RenewBefore = time.Minute
+	if _, err := store.Get(storeTestClusterID); err != nil {
+		t.Fatal(err)
+	}
+	if mock.downloads != 2 {
+		t.Error("expected the cached bundle to be reused, downloads:", mock.downloads)
+	}
+}
+
+func TestCredentialsStorePrunesDeletedClusters(t *testing.T) {
+	store, mock := newTestCredentialsStore(t)
+	store.Key = &CredentialsKey{Passphrase: []byte("secret")}
+
+	if _, err := store.Get("mycluster"); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.clusters = nil
+	removed, err := store.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != storeTestClusterID {
+		t.Error("expected the deleted cluster to be pruned, got", removed)
+	}
+	if _, err = os.Stat(store.path(storeTestClusterID)); !os.IsNotExist(err) {
+		t.Error("expected the cached bundle to be removed")
+	}
+
+	if _, err = store.Get(storeTestClusterID); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Error("expected a 404 for a deleted cluster, got", err)
+	}
+}
+
+func TestCredentialsStoreListReportsBadBundles(t *testing.T) {
+	store, _ := newTestCredentialsStore(t)
+	if _, err := store.Get("mycluster"); err != nil {
+		t.Fatal(err)
+	}
+
+	corruptID := "00000000-aeb4-4c7c-91ef-e13ff94e352c"
+	corruptPath := store.path(corruptID)
+	if err := os.MkdirAll(filepath.Join(corruptPath, "ca.pem"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 2 {
+		t.Fatalf("expected both bundles to be listed, got %+v", cached)
+	}
+	if cached[0].ClusterID != corruptID || cached[0].Err == nil || cached[0].Path != corruptPath {
+		t.Errorf("expected the corrupt bundle to report an error, got %+v", cached[0])
+	}
+	if cached[1].ClusterID != storeTestClusterID || cached[1].Err != nil || !cached[1].Fresh {
+		t.Errorf("expected the good bundle to be listed, got %+v", cached[1])
+	}
+}
@@ -0,0 +1,68 @@
+package libcarina
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates spans for CarinaClient operations, such as GetCredentials, and for each HTTP request they make.
+// Its shape matches OpenTelemetry, see the carinaotel package for an adapter.
+type Tracer interface {
+	// Start creates a span which is a child of any span in ctx, returning a context which contains the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+
+	// Inject adds the trace context of the span in ctx to the request headers, e.g. the W3C traceparent header
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a traced unit of work
+type Span interface {
+	// SetAttribute records a property of the span, e.g. http.status_code
+	SetAttribute(key string, value interface{})
+
+	// RecordError marks the span as failed
+	RecordError(err error)
+
+	// End completes the span
+	End()
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopTracer) Inject(ctx context.Context, header http.Header) {}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) RecordError(err error)                      {}
+func (nopSpan) End()                                       {}
+
+func (c *CarinaClient) tracer() Tracer {
+	if c.Tracer == nil {
+		return nopTracer{}
+	}
+	return c.Tracer
+}
+
+// startOperation begins a CarinaClient operation, e.g. List, returning its context and a function which ends its span.
+// The span is a child of any span in ctx, e.g. from ListContext. The function returns the error it was given,
+// so that it can wrap the return value of the operation.
+func (c *CarinaClient) startOperation(ctx context.Context, operation string) (context.Context, func(error) error) {
+	ctx = withOperation(ctx, operation)
+	ctx, span := c.tracer().Start(ctx, "libcarina."+operation)
+	return ctx, func(err error) error {
+		endSpan(span, err)
+		return err
+	}
+}
+
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,121 @@
+package libcarina
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type testSpanKey struct{}
+
+// testTracer records the names of the spans, indented by their depth
+type testTracer struct {
+	spans []string
+}
+
+type testSpan struct {
+	tracer *testTracer
+	name   string
+	depth  int
+	err    error
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &testSpan{tracer: tr, name: name}
+	if parent, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		span.depth = parent.depth + 1
+	}
+	return context.WithValue(ctx, testSpanKey{}, span), span
+}
+
+func (tr *testTracer) Inject(ctx context.Context, header http.Header) {
+	if span, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		header.Set("traceparent", span.name)
+	}
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {}
+
+func (s *testSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *testSpan) End() {
+	entry := strings.Repeat("  ", s.depth) + s.name
+	if s.err != nil {
+		entry += " (error)"
+	}
+	s.tracer.spans = append(s.tracer.spans, entry)
+}
+
+func TestTracer(t *testing.T) {
+	var traceparents []string
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		switch {
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusConflict)
+		case r.URL.Path == "/clusters":
+			fmt.Fprintf(w, `{"clusters": [{"id": "%s", "name": "mycluster"}]}`, storeTestClusterID)
+		case strings.HasPrefix(r.URL.Path, "/clusters/"+storeTestClusterID):
+			fmt.Fprintf(w, `{"id": "%s", "name": "mycluster"}`, storeTestClusterID)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	tracer := &testTracer{}
+	carinaClient.Tracer = tracer
+
+	if _, err := carinaClient.Resize("mycluster", 2); err != nil {
+		t.Fatal(err)
+	}
+	carinaClient.Delete(storeTestClusterID)
+
+	expected := []string{
+		"  HTTP GET",
+		"  HTTP POST",
+		"  HTTP GET",
+		"libcarina.Resize",
+		"  HTTP DELETE (error)",
+		"libcarina.Delete (error)",
+	}
+	if strings.Join(tracer.spans, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected spans:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(tracer.spans, "\n"))
+	}
+	for _, traceparent := range traceparents {
+		if !strings.HasPrefix(traceparent, "HTTP ") {
+			t.Error("expected the request span to be propagated, got", traceparent)
+		}
+	}
+}
+
+func TestTracerContext(t *testing.T) {
+	carinaClient := newTestMiddlewareClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id": "%s", "name": "mycluster"}`, storeTestClusterID)
+	})
+	tracer := &testTracer{}
+	carinaClient.Tracer = tracer
+
+	ctx, caller := tracer.Start(context.Background(), "caller")
+	if _, err := carinaClient.GetContext(ctx, storeTestClusterID); err != nil {
+		t.Fatal(err)
+	}
+	caller.End()
+
+	expected := []string{
+		"    HTTP GET",
+		"  libcarina.Get",
+		"caller",
+	}
+	if strings.Join(tracer.spans, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the operation to be a child of the caller's span\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(tracer.spans, "\n"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := carinaClient.GetContext(ctx, storeTestClusterID); err == nil {
+		t.Error("expected the request to be cancelled with ctx")
+	}
+}
@@ -0,0 +1,185 @@
+package libcarina
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultDialTimeout is the default limit on establishing a connection to a cluster
+	DefaultDialTimeout = 30 * time.Second
+
+	// DefaultKeepAlive is the default keep-alive period for connections to a cluster
+	DefaultKeepAlive = 30 * time.Second
+
+	// DefaultIdleConnTimeout is the default time an idle connection to a cluster is kept open
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// HTTPClientOpts defines the set of parameters when creating an HTTP client for a cluster
+type HTTPClientOpts struct {
+	// Timeout limits each request, including reading the response body. Defaults to no limit, which suits streaming APIs such as logs.
+	Timeout time.Duration
+
+	// DialTimeout limits establishing a connection, including the TLS handshake, defaults to DefaultDialTimeout
+	DialTimeout time.Duration
+
+	// KeepAlive is the TCP keep-alive period, defaults to DefaultKeepAlive. A negative value disables keep-alives.
+	KeepAlive time.Duration
+
+	// IdleConnTimeout is how long an idle connection is kept for reuse, defaults to DefaultIdleConnTimeout
+	IdleConnTimeout time.Duration
+
+	// DisableKeepAlives prevents connections from being reused between requests
+	DisableKeepAlives bool
+
+	// Proxy routes connections through a proxy, defaults to CredentialsBundle.Proxy
+	Proxy *ProxyConfig
+}
+
+func defaultDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   DefaultDialTimeout,
+		KeepAlive: DefaultKeepAlive,
+	}
+}
+
+func (opts *HTTPClientOpts) dialer() *net.Dialer {
+	dialer := defaultDialer()
+	if opts.DialTimeout > 0 {
+		dialer.Timeout = opts.DialTimeout
+	}
+	if opts.KeepAlive != 0 {
+		dialer.KeepAlive = opts.KeepAlive
+	}
+	return dialer
+}
+
+// ClusterHTTPClient is an HTTP client for the Docker or Kubernetes API of a cluster
+type ClusterHTTPClient struct {
+	*http.Client
+
+	// BaseURL of the COE API, e.g. https://172.99.65.11:2376
+	BaseURL *url.URL
+}
+
+// NewRequest creates a request for a path relative to the base URL of the COE API, e.g. /v1.24/info or /api/v1/pods
+func (c *ClusterHTTPClient) NewRequest(method string, uri string, body io.Reader) (*http.Request, error) {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	requestURL := *c.BaseURL
+	requestURL.Path = strings.TrimRight(requestURL.Path, "/") + "/" + strings.TrimLeft(ref.Path, "/")
+	requestURL.RawQuery = ref.RawQuery
+
+	req, err := http.NewRequest(method, requestURL.String(), body)
+	return req, errors.WithStack(err)
+}
+
+// BaseURL returns the URL of the COE API, e.g. https://172.99.65.11:2376 for a Swarm cluster
+func (creds *CredentialsBundle) BaseURL() (*url.URL, error) {
+	hostURL, err := creds.parseHostURL()
+	if err != nil {
+		return nil, err
+	}
+
+	// Docker uses tcp://host:port even though the API is served over HTTPS
+	baseURL := *hostURL
+	baseURL.Scheme = "https"
+	return &baseURL, nil
+}
+
+// HTTPClient creates an HTTP client which authenticates to the COE API with the certificates in the credentials bundle.
+// The TLS configuration comes from GetTLSConfig, which sets InsecureSkipVerify, so the server certificate is not verified against ca.pem.
+func (creds *CredentialsBundle) HTTPClient(opts *HTTPClientOpts) (*ClusterHTTPClient, error) {
+	if creds.Err != nil {
+		return nil, creds.Err
+	}
+	if opts == nil {
+		opts = &HTTPClientOpts{}
+	}
+
+	baseURL, err := creds.BaseURL()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := creds.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := opts.dialer()
+	idleConnTimeout := opts.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = DefaultIdleConnTimeout
+	}
+
+	proxyConfig := opts.Proxy
+	if proxyConfig == nil {
+		proxyConfig = creds.Proxy
+	}
+
+	transport := &http.Transport{
+		Proxy:               proxyConfig.ProxyFunc(),
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: dialer.Timeout,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableKeepAlives:   opts.DisableKeepAlives,
+		MaxIdleConnsPerHost: 10,
+	}
+
+	return &ClusterHTTPClient{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+		},
+		BaseURL: baseURL,
+	}, nil
+}
+
+// DialContext opens a TLS connection to the COE Endpoint, authenticating with the certificates in the credentials bundle.
+// When address is empty, the host from ParseHost is used. The connection is routed through CredentialsBundle.Proxy.
+// The connection returned has already completed the TLS handshake, so its signature matches http.Transport.DialTLSContext,
+// not http.Transport.DialContext. Like HTTPClient, the server certificate is not verified, see GetTLSConfig.
+func (creds *CredentialsBundle) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if creds.Err != nil {
+		return nil, creds.Err
+	}
+
+	tlsConfig, err := creds.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if address == "" {
+		address, err = creds.ParseHost()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := creds.Proxy.dialContext(ctx, defaultDialer(), network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid credentials bundle. Unable to connect to %s", address)
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Invalid credentials bundle. Unable to connect to %s", address)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,81 @@
+package libcarina
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCOEServer starts a TLS server which requires a client certificate, and a Swarm bundle which connects to it
+func newTestCOEServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *CredentialsBundle) {
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	ca, cert, key := newTestCertificates(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	creds := newTestBundle(map[string]string{
+		"docker.env": fmt.Sprintf("export DOCKER_HOST=tcp://%s\nexport DOCKER_TLS_VERIFY=1\n", server.Listener.Addr()),
+		"ca.pem":     string(ca),
+		"cert.pem":   string(cert),
+		"key.pem":    string(key),
+	})
+	return server, creds
+}
+
+func TestHTTPClient(t *testing.T) {
+	_, creds := newTestCOEServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.URL.Path, r.URL.RawQuery)
+	})
+
+	client, err := creds.HTTPClient(&HTTPClientOpts{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL.Scheme != "https" {
+		t.Error("expected an https base URL, got", client.BaseURL)
+	}
+
+	req, err := client.NewRequest("GET", "/v1.24/containers/json?all=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "/v1.24/containers/json all=1" {
+		t.Error("unexpected response", resp.Status, string(body))
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	_, creds := newTestCOEServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	conn, err := creds.DialContext(context.Background(), "tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if err = creds.Verify(); err != nil {
+		t.Error("expected the credentials to be verified, got", err)
+	}
+
+	creds.Files["docker.env"] = []byte("export DOCKER_HOST=tcp://127.0.0.1:1\n")
+	if err = creds.Verify(); err == nil || !strings.Contains(err.Error(), "Unable to connect") {
+		t.Error("expected a connection error, got", err)
+	}
+}